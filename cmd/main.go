@@ -7,8 +7,10 @@ import (
 	"syscall"
 
 	"go-crawler-client/config"
+	"go-crawler-client/internal/account"
 	"go-crawler-client/internal/api"
 	"go-crawler-client/internal/auth"
+	"go-crawler-client/internal/crawler"
 	"go-crawler-client/internal/service"
 	"go-crawler-client/internal/socket"
 )
@@ -19,6 +21,11 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// Proxy of Pixiv requests and of the WebSocket
+	if err := crawler.InitProxy(); err != nil {
+		log.Fatalf("Invalid proxy settings: %v", err)
+	}
+
 	// Init Token Validator
 	var err error
 	api.TokenValidator, err = auth.NewTokenValidator()
@@ -33,6 +40,16 @@ func main() {
 	// Init Task Manager (and directories)
 	service.InitTaskManager()
 
+	// Pixiv accounts registered on this client, tasks refer to them by alias
+	if err := account.InitVault(); err != nil {
+		log.Printf("Warning: Failed to open account vault: %v. Tasks need a cookie.", err)
+	}
+
+	// Tasks that were running when the client stopped come back as interrupted
+	if n := service.GlobalTaskManager.LoadInterrupted(); n > 0 {
+		log.Printf("Restored %d interrupted task(s), send resume_task to continue them.", n)
+	}
+
 	// Check for Token and Login if missing
 	if config.GlobalConfig.Token == "" {
 		log.Println("Token not found in config. Starting interactive login...")
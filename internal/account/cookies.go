@@ -0,0 +1,203 @@
+package account
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-crawler-client/internal/pkg/sqlite"
+)
+
+// Formats of cookie files accepted by ImportCookies
+const (
+	FormatNetscape = "netscape" // cookies.txt, as written by curl, wget and the browser export extensions
+	FormatFirefox  = "firefox"  // cookies.sqlite of a Firefox profile
+)
+
+// pixivURL is the page the imported cookies are resolved for, the cookie header of a task is the one a browser sends to it
+var pixivURL = &url.URL{Scheme: "https", Host: "www.pixiv.net", Path: "/"}
+
+// DetectFormat guesses the format of a cookie file from its content
+func DetectFormat(data []byte) string {
+	if bytes.HasPrefix(data, []byte("SQLite format 3\x00")) {
+		return FormatFirefox
+	}
+	return FormatNetscape
+}
+
+// ImportCookies reads the pixiv.net cookies of a cookie file and returns the Cookie header they make for
+// www.pixiv.net. wal is the -wal file next to a cookies.sqlite, Firefox keeps recent logins there until it
+// checkpoints; it is nil for cookies.txt. Expired cookies are dropped
+func ImportCookies(data []byte, wal []byte, format string) (string, error) {
+	if format == "" {
+		format = DetectFormat(data)
+	}
+
+	var cookies []*http.Cookie
+	var err error
+	switch format {
+	case FormatNetscape:
+		cookies, err = parseNetscape(data)
+	case FormatFirefox:
+		cookies, err = readFirefox(data, wal)
+	default:
+		return "", fmt.Errorf("invalid cookie file format: %s", format)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// The jar applies the browser rules: domain and path matching, expiry, and the most specific cookie first
+	jar, _ := cookiejar.New(nil)
+	for _, cookie := range cookies {
+		host := strings.TrimPrefix(cookie.Domain, ".")
+		if host != "pixiv.net" && !strings.HasSuffix(host, ".pixiv.net") {
+			continue
+		}
+		if !strings.HasPrefix(cookie.Domain, ".") {
+			cookie.Domain = "" // host-only cookie
+		}
+		jar.SetCookies(&url.URL{Scheme: "https", Host: host, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+
+	sent := jar.Cookies(pixivURL)
+	if len(sent) == 0 {
+		return "", errors.New("the cookie file has no unexpired pixiv.net cookies")
+	}
+	pairs := make([]string, 0, len(sent))
+	for _, cookie := range sent {
+		pairs = append(pairs, cookie.Name+"="+cookie.Value)
+	}
+	return strings.Join(pairs, "; "), nil
+}
+
+// parseNetscape parses a cookies.txt: one cookie per line, the fields domain, include subdomains, path,
+// secure, expiry and name, value separated by tabs. "#HttpOnly_" before the domain is not a comment
+func parseNetscape(data []byte) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(text, "#HttpOnly_"); ok {
+			text, httpOnly = rest, true
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) == 6 {
+			fields = append(fields, "") // cookie without value
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("cookies.txt line %d: expected 7 tab separated fields, got %d", line, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies.txt line %d: invalid expiry %q", line, fields[4])
+		}
+
+		domain := fields[0]
+		if strings.EqualFold(fields[1], "TRUE") && !strings.HasPrefix(domain, ".") {
+			domain = "." + domain
+		}
+		cookies = append(cookies, &http.Cookie{
+			Domain:   domain,
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Expires:  expiryTime(expiry),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cookies, nil
+}
+
+// readFirefox reads the moz_cookies table of a cookies.sqlite. Columns are looked up by name, their order
+// changed across Firefox versions
+func readFirefox(data []byte, wal []byte) ([]*http.Cookie, error) {
+	db, err := sqlite.Open(data, wal)
+	if err != nil {
+		return nil, err
+	}
+	table, err := db.Table("moz_cookies")
+	if err != nil {
+		return nil, fmt.Errorf("not a Firefox cookie database: %w", err)
+	}
+	rows, err := db.Rows(table)
+	if err != nil {
+		return nil, err
+	}
+
+	text := func(row sqlite.Row, col string) string {
+		s, _ := table.Column(row, col).(string)
+		return s
+	}
+	integer := func(row sqlite.Row, col string) int64 {
+		n, _ := table.Column(row, col).(int64)
+		return n
+	}
+
+	cookies := make([]*http.Cookie, 0, len(rows))
+	for _, row := range rows {
+		// Cookies of containers and private windows are not the ones of the logged in profile
+		if text(row, "originAttributes") != "" {
+			continue
+		}
+		cookies = append(cookies, &http.Cookie{
+			Domain:   text(row, "host"),
+			Path:     text(row, "path"),
+			Secure:   integer(row, "isSecure") != 0,
+			Expires:  expiryTime(integer(row, "expiry")),
+			Name:     text(row, "name"),
+			Value:    text(row, "value"),
+			HttpOnly: integer(row, "isHttpOnly") != 0,
+		})
+	}
+	return cookies, nil
+}
+
+// expiryTime converts a Unix expiry, 0 meaning a session cookie. Recent Firefox versions store milliseconds
+func expiryTime(expiry int64) time.Time {
+	switch {
+	case expiry <= 0:
+		return time.Time{}
+	case expiry > 1e11:
+		return time.UnixMilli(expiry)
+	default:
+		return time.Unix(expiry, 0)
+	}
+}
+
+// SessionUserID returns the Pixiv user ID a cookie header is logged in as, read from its PHPSESSID
+// ("<user id>_<token>"). It returns "" for a guest session
+func SessionUserID(cookie string) string {
+	for _, part := range strings.Split(cookie, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "PHPSESSID" {
+			continue
+		}
+		userID, _, ok := strings.Cut(value, "_")
+		if !ok {
+			return ""
+		}
+		if _, err := strconv.ParseUint(userID, 10, 64); err != nil {
+			return ""
+		}
+		return userID
+	}
+	return ""
+}
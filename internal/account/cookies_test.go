@@ -0,0 +1,87 @@
+package account
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportCookies(t *testing.T) {
+	firefox, err := os.ReadFile("../pkg/sqlite/testdata/cookies.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firefoxWAL, err := os.ReadFile("../pkg/sqlite/testdata/cookies.sqlite-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	netscape := []byte("# Netscape HTTP Cookie File\n" +
+		"#HttpOnly_.pixiv.net\tTRUE\t/\tTRUE\t4102444800\tPHPSESSID\t12345678_abc\n" +
+		".pixiv.net\tTRUE\t/\tFALSE\t0\tp_ab_id\t3\n" +
+		".google.com\tTRUE\t/\tFALSE\t0\tNID\t1\n" +
+		"www.pixiv.net\tFALSE\t/\tFALSE\t1000000000\texpired\t1\n")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wal     []byte
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "firefox with write-ahead log",
+			data: firefox, wal: firefoxWAL,
+			// Container cookies and expired cookies left out, expiry in milliseconds understood
+			want: "PHPSESSID=12345678_newtoken; p_ab_id=3; long=5000 bytes; device_token=dt",
+		},
+		{name: "firefox without log", data: firefox, want: "PHPSESSID=12345678_oldtoken; p_ab_id=3; long=5000 bytes"},
+		{name: "netscape", data: netscape, want: "PHPSESSID=12345678_abc; p_ab_id=3"},
+		{name: "netscape forced", data: netscape, format: FormatNetscape, want: "PHPSESSID=12345678_abc; p_ab_id=3"},
+		{name: "no pixiv cookies", data: []byte(".google.com\tTRUE\t/\tFALSE\t0\tNID\t1\n"), wantErr: true},
+		{name: "malformed line", data: []byte("pixiv.net\tTRUE\t/\n"), wantErr: true},
+		{name: "corrupt database", data: firefox[:2048], wantErr: true},
+		{name: "unknown format", data: netscape, format: "chrome", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ImportCookies(tt.data, tt.wal, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportCookies() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := summarize(got); got != tt.want {
+				t.Errorf("ImportCookies() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// summarize replaces the values of long cookies with their length
+func summarize(header string) string {
+	pairs := strings.Split(header, "; ")
+	for i, pair := range pairs {
+		if name, value, _ := strings.Cut(pair, "="); len(value) > 100 {
+			pairs[i] = fmt.Sprintf("%s=%d bytes", name, len(value))
+		}
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func TestSessionUserID(t *testing.T) {
+	tests := map[string]string{
+		"PHPSESSID=12345678_abc; p_ab_id=3": "12345678",
+		"p_ab_id=3; PHPSESSID=42_x":         "42",
+		"PHPSESSID=abc_def":                 "",
+		"PHPSESSID=guesttoken":              "",
+		"p_ab_id=3":                         "",
+	}
+	for cookie, want := range tests {
+		if got := SessionUserID(cookie); got != want {
+			t.Errorf("SessionUserID(%q) = %q, want %q", cookie, got, want)
+		}
+	}
+}
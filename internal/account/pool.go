@@ -0,0 +1,286 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go-crawler-client/internal/model"
+)
+
+// Cooldown of an account answered with 403 or 429, doubled for every further strike in a row
+const (
+	cooldownBase = 1 * time.Minute
+	cooldownMax  = 30 * time.Minute
+)
+
+// accountHeader names the account a request was prepared with. Transport reads it to count the request
+// and removes it before the request leaves the client
+const accountHeader = "X-Crawler-Account"
+
+// accountHost is the host the cookies of the accounts belong to. Requests to other hosts, like the files
+// of i.pximg.net, never carry them and do not count against an account
+const accountHost = "www.pixiv.net"
+
+var errNoAccounts = errors.New("no usable account left")
+
+// Member is an account of a pool. Tasks started with a raw cookie get a pool of a single member without alias
+type Member struct {
+	Alias  string
+	Cookie string
+}
+
+// Pool spreads the requests of a task across accounts, skipping the ones on cooldown
+type Pool struct {
+	mu      sync.Mutex
+	members []Member
+	dropped map[string]bool // logged out accounts, never handed out again
+	next    int
+}
+
+// NewPool builds the pool of a task: its accounts when it names any, the raw cookie otherwise.
+// A pool without members is a guest session
+func NewPool(aliases []string, cookie string) (*Pool, error) {
+	p := &Pool{dropped: make(map[string]bool)}
+	if len(aliases) == 0 {
+		if cookie != "" {
+			p.members = append(p.members, Member{Cookie: cookie})
+		}
+		return p, nil
+	}
+
+	if GlobalVault == nil {
+		return nil, errors.New("account vault is not available")
+	}
+	seen := make(map[string]bool, len(aliases))
+	for _, alias := range aliases {
+		if seen[alias] {
+			continue
+		}
+		seen[alias] = true
+		acc, ok := GlobalVault.Get(alias)
+		if !ok {
+			return nil, fmt.Errorf("account not found: %s", alias)
+		}
+		p.members = append(p.members, Member{Alias: alias, Cookie: acc.Cookie})
+	}
+	return p, nil
+}
+
+// Members returns the accounts of the pool that were not dropped
+func (p *Pool) Members() []Member {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	members := make([]Member, 0, len(p.members))
+	for _, m := range p.members {
+		if !p.dropped[m.Alias] {
+			members = append(members, m)
+		}
+	}
+	return members
+}
+
+// Guest reports whether the pool has no cookie at all
+func (p *Pool) Guest() bool {
+	return len(p.members) == 0
+}
+
+// Drop removes a logged out account from the pool and returns how many accounts are left
+func (p *Pool) Drop(alias string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropped[alias] = true
+	left := 0
+	for _, m := range p.members {
+		if !p.dropped[m.Alias] {
+			left++
+		}
+	}
+	return left
+}
+
+// Next hands out the accounts in turn. When every account is on cooldown it waits for the first one to
+// come back, or for ctx to be done. A guest pool returns an empty member
+func (p *Pool) Next(ctx context.Context) (Member, error) {
+	if p.Guest() {
+		return Member{}, nil
+	}
+	for {
+		m, wait, err := p.pick()
+		if err != nil || wait == 0 {
+			return m, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return Member{}, ctx.Err()
+		}
+	}
+}
+
+// pick returns the next account off cooldown, or how long until one is
+func (p *Pool) pick() (Member, time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	usable := false
+	for i := 0; i < len(p.members); i++ {
+		m := p.members[(p.next+i)%len(p.members)]
+		if p.dropped[m.Alias] {
+			continue
+		}
+		usable = true
+		until := cooldownUntil(m.Alias)
+		if !until.After(now) {
+			p.next = (p.next + i + 1) % len(p.members)
+			return m, 0, nil
+		}
+		if d := until.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	if !usable {
+		return Member{}, 0, errNoAccounts
+	}
+	return Member{}, wait, nil
+}
+
+// Tag marks a request as sent with an account, so Transport counts it
+func Tag(header http.Header, alias string) {
+	if alias != "" {
+		header.Set(accountHeader, alias)
+	}
+}
+
+// Tagged returns the account a request was tagged with
+func Tagged(header http.Header) string {
+	return header.Get(accountHeader)
+}
+
+// Usage counters, shared by every task: an account on cooldown is left alone by all of them
+type usage struct {
+	requests  int
+	throttled int // 403 and 429 answers
+	strikes   int // throttled answers in a row
+	cooldown  time.Time
+	lastUsed  time.Time
+}
+
+var (
+	usageMu sync.Mutex
+	usages  = make(map[string]*usage)
+)
+
+func cooldownUntil(alias string) time.Time {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	if u, ok := usages[alias]; ok {
+		return u.cooldown
+	}
+	return time.Time{}
+}
+
+// Report counts a request of an account, a 403 or 429 answer puts the account on cooldown
+func Report(alias string, statusCode int) {
+	if alias == "" {
+		return
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	u, ok := usages[alias]
+	if !ok {
+		u = &usage{}
+		usages[alias] = u
+	}
+	u.requests++
+	u.lastUsed = time.Now()
+
+	switch {
+	case statusCode == http.StatusForbidden || statusCode == http.StatusTooManyRequests:
+		u.throttled++
+		u.strikes++
+		cooldown := cooldownBase << min(u.strikes-1, 5)
+		u.cooldown = time.Now().Add(min(cooldown, cooldownMax))
+	case statusCode >= 200 && statusCode < 300:
+		u.strikes = 0
+	}
+}
+
+// Usage returns the request counters of every account used since the client started, ordered by alias
+func Usage() []model.AccountUsage {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	now := time.Now()
+	stats := make([]model.AccountUsage, 0, len(usages))
+	for alias, u := range usages {
+		stat := model.AccountUsage{
+			Alias:     alias,
+			Requests:  u.requests,
+			Throttled: u.throttled,
+			LastUsed:  u.lastUsed.Format(time.RFC3339),
+		}
+		if u.cooldown.After(now) {
+			stat.CooldownUntil = u.cooldown.Format(time.RFC3339)
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Alias < stats[j].Alias })
+	return stats
+}
+
+// Transport counts the requests to www.pixiv.net tagged with an account and reports their status. Requests
+// without a cookie whose context carries a pool (see WithPool) get the next account of the pool first
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+type poolKey struct{}
+
+// WithPool returns a context whose requests take turns among the accounts of the pool, a nil pool
+// leaves them without account
+func WithPool(ctx context.Context, pool *Pool) context.Context {
+	return context.WithValue(ctx, poolKey{}, pool)
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	alias := req.Header.Get(accountHeader)
+	pool, _ := req.Context().Value(poolKey{}).(*Pool)
+	if req.URL.Hostname() != accountHost {
+		alias, pool = "", nil
+	}
+	if alias == "" && pool != nil && req.Header.Get("Cookie") == "" && !pool.Guest() {
+		m, err := pool.Next(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		alias = m.Alias
+		req = req.Clone(req.Context())
+		req.Header.Set("Cookie", m.Cookie)
+	}
+	if req.Header.Get(accountHeader) != "" {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Header.Del(accountHeader)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		Report(alias, resp.StatusCode)
+	}
+	return resp, err
+}
@@ -0,0 +1,317 @@
+package account
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-crawler-client/config"
+	"go-crawler-client/internal/model"
+)
+
+const (
+	keyFile   = "vault.key"
+	vaultFile = "accounts.vault"
+	keySize   = 32 // AES-256
+)
+
+// vaultAAD binds the ciphertext to this file format, a vault of another format fails to open instead of decoding garbage
+var vaultAAD = []byte("go-crawler-client accounts v1")
+
+// Account is a Pixiv account registered on the client, tasks refer to it by alias
+type Account struct {
+	Alias     string `json:"alias"`
+	Cookie    string `json:"cookie"`
+	UserID    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	Premium   bool   `json:"premium"`
+	AddedAt   string `json:"added_at"`
+	CheckedAt string `json:"checked_at,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+// Info returns the account with its cookie redacted, safe to send to the backend
+func (a Account) Info() model.AccountInfo {
+	return model.AccountInfo{
+		Alias:     a.Alias,
+		UserID:    a.UserID,
+		UserName:  a.UserName,
+		Premium:   a.Premium,
+		Cookie:    RedactCookie(a.Cookie),
+		AddedAt:   a.AddedAt,
+		CheckedAt: a.CheckedAt,
+		Status:    a.Status,
+	}
+}
+
+// Vault keeps accounts encrypted with AES-256-GCM in <base dir>/.vault/accounts.vault.
+// The key is generated on first use into vault.key next to it, readable by the owner only.
+// The encryption protects the vault file on its own, not the directory: a copy of the base
+// directory holds the key as well and opens the vault
+type Vault struct {
+	mu       sync.Mutex
+	dir      string
+	key      []byte
+	accounts map[string]Account // by alias
+}
+
+var GlobalVault *Vault
+
+// InitVault opens the vault of the base directory
+func InitVault() error {
+	v, err := Open(filepath.Join(config.GetBaseDir(), ".vault"))
+	if err != nil {
+		return err
+	}
+	GlobalVault = v
+	return nil
+}
+
+// Open loads the vault of a directory, creating its key when there is neither key nor vault yet
+func Open(dir string) (*Vault, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	v := &Vault{dir: dir, accounts: make(map[string]Account)}
+
+	key, err := os.ReadFile(filepath.Join(dir, keyFile))
+	switch {
+	case err == nil:
+		if len(key) != keySize {
+			return nil, fmt.Errorf("invalid vault key: %d bytes", len(key))
+		}
+	case os.IsNotExist(err):
+		// Without its key an existing vault is lost, never replace the key silently
+		if _, statErr := os.Stat(filepath.Join(dir, vaultFile)); statErr == nil {
+			return nil, errors.New("vault key is missing, the accounts cannot be decrypted")
+		}
+		if key, err = newKey(filepath.Join(dir, keyFile)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	v.key = key
+
+	if err := v.load(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func newKey(path string) ([]byte, error) {
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	// O_EXCL: two clients starting at once must not end up with different keys
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return key, f.Close()
+}
+
+func (v *Vault) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(v.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (v *Vault) load() error {
+	data, err := os.ReadFile(filepath.Join(v.dir, vaultFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	aead, err := v.aead()
+	if err != nil {
+		return err
+	}
+	if len(data) < aead.NonceSize() {
+		return errors.New("vault is truncated")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], vaultAAD)
+	if err != nil {
+		return errors.New("vault cannot be decrypted with this key")
+	}
+
+	var accounts []Account
+	if err := json.Unmarshal(plain, &accounts); err != nil {
+		return err
+	}
+	for _, acc := range accounts {
+		v.accounts[acc.Alias] = acc
+	}
+	return nil
+}
+
+// update applies a change to a copy of the accounts and keeps it once the vault file is written,
+// a failed write leaves both the file and the accounts in memory as they were
+func (v *Vault) update(change func(accounts map[string]Account) error) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	accounts := maps.Clone(v.accounts)
+	if err := change(accounts); err != nil {
+		return err
+	}
+	if err := v.save(accounts); err != nil {
+		return err
+	}
+	v.accounts = accounts
+	return nil
+}
+
+// save encrypts the accounts with a fresh nonce and replaces the vault file, must be called with mu held
+func (v *Vault) save(byAlias map[string]Account) error {
+	accounts := make([]Account, 0, len(byAlias))
+	for _, acc := range byAlias {
+		accounts = append(accounts, acc)
+	}
+	plain, err := json.Marshal(accounts)
+	if err != nil {
+		return err
+	}
+
+	aead, err := v.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := aead.Seal(nonce, nonce, plain, vaultAAD)
+
+	// Write then rename, a crash mid-write must not destroy the previous vault
+	path := filepath.Join(v.dir, vaultFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Put adds an account, or replaces the account of the same alias
+func (v *Vault) Put(acc Account) error {
+	if err := ValidateAlias(acc.Alias); err != nil {
+		return err
+	}
+	if strings.TrimSpace(acc.Cookie) == "" {
+		return errors.New("missing required field: cookie")
+	}
+	if acc.AddedAt == "" {
+		acc.AddedAt = time.Now().Format(time.RFC3339)
+	}
+
+	return v.update(func(accounts map[string]Account) error {
+		accounts[acc.Alias] = acc
+		return nil
+	})
+}
+
+// Get returns the account of an alias
+func (v *Vault) Get(alias string) (Account, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	acc, ok := v.accounts[alias]
+	return acc, ok
+}
+
+// List returns the accounts ordered by alias
+func (v *Vault) List() []Account {
+	v.mu.Lock()
+	accounts := make([]Account, 0, len(v.accounts))
+	for _, acc := range v.accounts {
+		accounts = append(accounts, acc)
+	}
+	v.mu.Unlock()
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Alias < accounts[j].Alias })
+	return accounts
+}
+
+// Remove deletes the account of an alias
+func (v *Vault) Remove(alias string) error {
+	return v.update(func(accounts map[string]Account) error {
+		if _, ok := accounts[alias]; !ok {
+			return fmt.Errorf("account not found: %s", alias)
+		}
+		delete(accounts, alias)
+		return nil
+	})
+}
+
+// RecordCheck stores the outcome of a cookie test, status being "ok" or an error code
+func (v *Vault) RecordCheck(alias string, status string, auth model.AuthStatus) error {
+	return v.update(func(accounts map[string]Account) error {
+		acc, ok := accounts[alias]
+		if !ok {
+			return fmt.Errorf("account not found: %s", alias)
+		}
+		acc.Status = status
+		acc.CheckedAt = time.Now().Format(time.RFC3339)
+		if auth.LoggedIn {
+			acc.UserID = auth.UserID
+			acc.UserName = auth.UserName
+			acc.Premium = auth.Premium
+		}
+		accounts[alias] = acc
+		return nil
+	})
+}
+
+// ValidateAlias checks that an alias is usable as an account name
+func ValidateAlias(alias string) error {
+	if alias == "" {
+		return errors.New("missing required field: alias")
+	}
+	if len(alias) > 64 {
+		return errors.New("alias is longer than 64 bytes")
+	}
+	for _, r := range alias {
+		if r < 0x20 || r == 0x7f {
+			return errors.New("alias contains control characters")
+		}
+	}
+	return nil
+}
+
+// RedactCookie keeps the names of a cookie and hides its values, e.g. "PHPSESSID=***; device_token=***"
+func RedactCookie(cookie string) string {
+	parts := strings.Split(cookie, ";")
+	redacted := make([]string, 0, len(parts))
+	for _, part := range parts {
+		name, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		redacted = append(redacted, name+"=***")
+	}
+	return strings.Join(redacted, "; ")
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"path/filepath"
 	"strconv"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"go-crawler-client/config"
+	"go-crawler-client/internal/account"
 	"go-crawler-client/internal/auth"
 	"go-crawler-client/internal/crawler"
 	"go-crawler-client/internal/model"
@@ -21,7 +23,7 @@ var TokenValidator *auth.TokenValidator
 
 func StartTaskHandler(c *gin.Context) {
 	mode := c.Param("mode")
-	if mode != "image" && mode != "data" {
+	if !crawler.IsSupportedMode(mode) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mode"})
 		return
 	}
@@ -37,6 +39,11 @@ func StartTaskHandler(c *gin.Context) {
 		return
 	}
 
+	if err := crawler.ValidateTask(mode, req.PixivUserID, req.TaskOptions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
 	// Validate Token
 	if TokenValidator != nil {
 		claims, err := TokenValidator.ValidateTaskToken(req.Token)
@@ -57,25 +64,42 @@ func StartTaskHandler(c *gin.Context) {
 		return
 	}
 
-	// Get User Info (Sync)
-	userInfo, err := crawler.GetUserInfo(req.PixivUserID, req.Cookie)
+	// The cookies of registered accounts never leave the client, a task may spread its requests over several
+	pool, err := account.NewPool(req.AccountAliases(), req.Cookie)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info: " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the cookies first, an expired session would "complete" the task with works missing
+	if err := crawler.CheckPool(mode, pool, req.TaskOptions); err != nil {
+		authFailed(c, err)
 		return
 	}
 
+	// Get User Info (Sync), search tasks are not tied to a user
+	var userInfo model.UserInfo
+	if crawler.RequiresUser(mode) {
+		userInfo, err = crawler.ResolveUser(mode, req.PixivUserID, pool)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user info: " + err.Error()})
+			return
+		}
+	}
+
 	// Generate Task ID
 	taskID := uuid.New().String()
 
 	// Create Task
-	task, err := service.GlobalTaskManager.AddTask(taskID, mode, userInfo)
+	task, err := service.GlobalTaskManager.AddTask(taskID, mode, userInfo, req.TaskOptions)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create task: " + err.Error()})
 		return
 	}
+	task.Guest = pool.Guest()
 
 	// Start Crawler (Async)
-	go crawler.StartCrawler(task, req.Cookie)
+	go crawler.StartCrawler(task, pool)
 
 	// Return Response immediately
 	c.JSON(http.StatusOK, model.StartTaskResponse{
@@ -99,6 +123,91 @@ func GetTaskStatusHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, task.GetSnapshot())
 }
 
+// CancelTaskHandler stops a running or paused task
+func CancelTaskHandler(c *gin.Context) {
+	controlTask(c, (*service.Task).Cancel)
+}
+
+// PauseTaskHandler pauses a running task
+func PauseTaskHandler(c *gin.Context) {
+	controlTask(c, (*service.Task).Pause)
+}
+
+// ResumeTaskHandler resumes a paused task, or restarts a task interrupted by a client restart or failed by its session.
+// The latter needs a task token again, like starting a task, and the cookie unless the task uses registered accounts or was started as a guest
+func ResumeTaskHandler(c *gin.Context) {
+	task, ok := service.GlobalTaskManager.GetTask(c.Param("task_id"))
+	if !ok || !task.Resumable() {
+		controlTask(c, (*service.Task).Resume)
+		return
+	}
+
+	var req struct {
+		Cookie string `json:"cookie"` // not needed by tasks of registered accounts
+		Token  string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if TokenValidator == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server authentication configuration error"})
+		return
+	}
+	if _, err := TokenValidator.ValidateTaskToken(req.Token); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token: " + err.Error()})
+		return
+	}
+
+	pool, err := account.NewPool(task.Options.AccountAliases(), req.Cookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Only tasks started as a guest restart without a session
+	if pool.Guest() && !task.Guest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required field: cookie"})
+		return
+	}
+	if err := crawler.CheckPool(task.Mode, pool, task.Options); err != nil {
+		authFailed(c, err)
+		return
+	}
+
+	if err := task.Reopen(); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	go crawler.StartCrawler(task, pool)
+
+	c.JSON(http.StatusOK, gin.H{"task_id": task.ID, "status": "running"})
+}
+
+// authFailed answers a failed cookie check, sessions that cannot run the task come with their error code
+func authFailed(c *gin.Context, err error) {
+	var authErr *crawler.AuthError
+	if errors.As(err, &authErr) {
+		c.JSON(http.StatusForbidden, gin.H{"error": authErr.Message, "code": authErr.Code})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check cookie: " + err.Error()})
+}
+
+func controlTask(c *gin.Context, action func(*service.Task) error) {
+	taskID := c.Param("task_id")
+	task, ok := service.GlobalTaskManager.GetTask(taskID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+		return
+	}
+
+	if err := action(task); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task_id": taskID, "status": task.GetSnapshot().Status})
+}
+
 func GetTaskLogsHandler(c *gin.Context) {
 	taskID := c.Param("task_id")
 	tailStr := c.Query("tail")
@@ -123,6 +232,40 @@ func GetTaskLogsHandler(c *gin.Context) {
 	})
 }
 
+// VerifyArchiveHandler re-hashes a user's downloaded files against the archive index
+func VerifyArchiveHandler(c *gin.Context) {
+	dir, err := crawler.UserArchiveDir(c.Param("pixiv_user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := crawler.VerifyArchive(dir)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func GetLimitsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, crawler.GetRateLimits())
+}
+
+// SetLimitsHandler changes the process-wide request limits, fields left out keep their current value
+func SetLimitsHandler(c *gin.Context) {
+	limits := crawler.GetRateLimits()
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+	if err := crawler.SetRateLimits(limits); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, crawler.GetRateLimits())
+}
+
 func GetAvatarHandler(c *gin.Context) {
 	userID := c.Param("pixiv_user_id")
 	baseDir := config.GetBaseDir()
@@ -136,15 +279,19 @@ func HealthCheckHandler(c *gin.Context) {
 		Status:     "ok",
 		BaseDir:    config.GetBaseDir(),
 		TasksCount: service.GlobalTaskManager.Count(),
+		Accounts:   account.Usage(),
+		Proxies:    crawler.GetProxyStatus(),
 	})
 }
 
 func GetConfigHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, model.ConfigResponse{
 		User: model.UserConfig{
-			ServerURL: config.GlobalConfig.ServerURL,
-			ProxyHost: config.GlobalConfig.ProxyHost,
-			ProxyPort: config.GlobalConfig.ProxyPort,
+			ServerURL:   config.GlobalConfig.ServerURL,
+			ProxyMode:   config.GlobalConfig.ProxyMode,
+			ProxyHost:   config.GlobalConfig.ProxyHost,
+			ProxyPort:   config.GlobalConfig.ProxyPort,
+			ProxyBypass: config.GlobalConfig.ProxyBypass,
 		},
 		Client: model.ClientConfig{
 			BaseDir:   config.GetBaseDir(),
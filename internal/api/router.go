@@ -11,10 +11,16 @@ func SetupRouter() *gin.Engine {
 	{
 		v1.POST("/start/:mode", StartTaskHandler)
 		v1.GET("/status/:task_id", GetTaskStatusHandler)
+		v1.POST("/cancel/:task_id", CancelTaskHandler)
+		v1.POST("/pause/:task_id", PauseTaskHandler)
+		v1.POST("/resume/:task_id", ResumeTaskHandler)
 		v1.GET("/logs/:task_id", GetTaskLogsHandler)
 		v1.GET("/avatars/:pixiv_user_id", GetAvatarHandler)
+		v1.POST("/verify/:pixiv_user_id", VerifyArchiveHandler)
 		v1.GET("/health", HealthCheckHandler)
 		v1.GET("/config", GetConfigHandler)
+		v1.GET("/limits", GetLimitsHandler)
+		v1.PUT("/limits", SetLimitsHandler)
 	}
 
 	return r
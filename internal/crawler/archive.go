@@ -0,0 +1,386 @@
+package crawler
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+var profileIllustsPattern = regexp.MustCompile(`^/ajax/user/(\d+)/profile/illusts$`)
+
+// profileIllustsBatch is how many IDs are checked per profile/illusts request
+const profileIllustsBatch = 48
+
+// archiveIndex is the persistent list of works already archived in a task directory
+// (crawl-datas/<uid>/.index/archive.jsonl for user tasks).
+// It is an append-only JSONL file, the last line of a work wins, so a crash never loses earlier entries
+type archiveIndex struct {
+	mu    sync.Mutex
+	dir   string // task directory, file paths in the index are relative to it
+	path  string
+	works map[string]archivedWork
+	owner map[string]string // file path -> ID of the work it belongs to
+}
+
+type archivedWork struct {
+	ID         string         `json:"id"`
+	UpdateDate string         `json:"update_date"`
+	Files      []archivedFile `json:"files"`
+	ArchivedAt string         `json:"archived_at"`
+}
+
+type archivedFile struct {
+	URL    string `json:"url"`
+	Path   string `json:"path"` // relative to the task directory
+	Page   int    `json:"page"`
+	Kind   string `json:"kind"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Indexes are shared by every task of the same directory, so concurrent tasks on an artist see each other's work
+var archiveIndexes sync.Map
+
+// openArchiveIndex returns the index of a task directory, loading it from disk on first use
+func openArchiveIndex(dir string) *archiveIndex {
+	if val, ok := archiveIndexes.Load(dir); ok {
+		return val.(*archiveIndex)
+	}
+
+	index := &archiveIndex{
+		dir:   dir,
+		path:  filepath.Join(dir, ".index", "archive.jsonl"),
+		works: make(map[string]archivedWork),
+		owner: make(map[string]string),
+	}
+	index.load()
+
+	val, _ := archiveIndexes.LoadOrStore(dir, index)
+	return val.(*archiveIndex)
+}
+
+func (a *archiveIndex) load() {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var work archivedWork
+		if err := json.Unmarshal(scanner.Bytes(), &work); err != nil || work.ID == "" {
+			// A torn last line from a crash, everything before it is still valid
+			continue
+		}
+		a.set(work)
+		lines++
+	}
+
+	// Rewrite the file once it is mostly superseded entries
+	if lines > 2*len(a.works)+100 {
+		a.compact()
+	}
+}
+
+func (a *archiveIndex) compact() {
+	tmpPath := a.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return
+	}
+	encoder := json.NewEncoder(f)
+	for _, work := range a.works {
+		encoder.Encode(work)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return
+	}
+	os.Rename(tmpPath, a.path)
+}
+
+// set replaces the entry of a work, must be called with mu held (or before the index is shared)
+func (a *archiveIndex) set(work archivedWork) {
+	if old, ok := a.works[work.ID]; ok {
+		for _, file := range old.Files {
+			delete(a.owner, file.Path)
+		}
+	}
+	for _, file := range work.Files {
+		a.owner[file.Path] = work.ID
+	}
+	a.works[work.ID] = work
+}
+
+// Owner returns the ID of the work a file belongs to, path being relative to the task directory
+func (a *archiveIndex) Owner(path string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id, ok := a.owner[filepath.ToSlash(path)]
+	return id, ok
+}
+
+// FindFile returns the archived file whose relative path or file name is name
+func (a *archiveIndex) FindFile(name string) (archivedFile, bool) {
+	name = filepath.ToSlash(name)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, work := range a.works {
+		for _, file := range work.Files {
+			if file.Path == name || path.Base(file.Path) == name || strings.TrimPrefix(file.Path, ".download_imgs/") == name {
+				return file, true
+			}
+		}
+	}
+	return archivedFile{}, false
+}
+
+// Len returns the number of archived works
+func (a *archiveIndex) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.works)
+}
+
+// Lookup returns the archived entry of a work
+func (a *archiveIndex) Lookup(id string) (archivedWork, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	work, ok := a.works[id]
+	return work, ok
+}
+
+// Works returns the archived works ordered by ID
+func (a *archiveIndex) Works() []archivedWork {
+	a.mu.Lock()
+	works := make([]archivedWork, 0, len(a.works))
+	for _, work := range a.works {
+		works = append(works, work)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(works, func(i, j int) bool { return works[i].ID < works[j].ID })
+	return works
+}
+
+// Invalidate clears the update date of a work, so the next task downloads it again
+func (a *archiveIndex) Invalidate(id string) error {
+	work, ok := a.Lookup(id)
+	if !ok {
+		return nil
+	}
+	work.UpdateDate = ""
+	return a.append(work)
+}
+
+// Status compares a work against the index: "new" when it was never archived,
+// "updated" when Pixiv has a newer version or a file went missing, "unchanged" otherwise
+func (a *archiveIndex) Status(id string, updateDate string) string {
+	work, ok := a.Lookup(id)
+	if !ok {
+		return "new"
+	}
+	if !sameDate(work.UpdateDate, updateDate) {
+		return "updated"
+	}
+	for _, file := range work.Files {
+		info, err := os.Stat(filepath.Join(a.dir, file.Path))
+		if err != nil || info.Size() != file.Size {
+			return "updated"
+		}
+	}
+	return "unchanged"
+}
+
+// Record stores a fully downloaded work, hashing the files that have no checksum yet
+func (a *archiveIndex) Record(id string, updateDate string, images []model.ImageInfo) error {
+	work := archivedWork{
+		ID:         id,
+		UpdateDate: updateDate,
+		Files:      make([]archivedFile, 0, len(images)),
+		ArchivedAt: time.Now().Format(time.RFC3339),
+	}
+	for _, img := range images {
+		size, sum, err := fileChecksum(img)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(a.dir, img.Path)
+		if err != nil {
+			rel = img.Path
+		}
+		work.Files = append(work.Files, archivedFile{
+			URL:    img.URL,
+			Path:   filepath.ToSlash(rel),
+			Page:   img.Page,
+			Kind:   img.Kind,
+			Size:   size,
+			SHA256: sum,
+		})
+	}
+
+	return a.append(work)
+}
+
+// append writes an entry to the index file, superseding earlier entries of the work
+func (a *archiveIndex) append(work archivedWork) error {
+	line, err := json.Marshal(work)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	a.set(work)
+	return nil
+}
+
+// sameDate compares two Pixiv timestamps, which may differ in their time zone notation
+func sameDate(a string, b string) bool {
+	ta, errA := time.Parse(time.RFC3339, a)
+	tb, errB := time.Parse(time.RFC3339, b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ta.Equal(tb)
+}
+
+// fileChecksum returns the size and SHA-256 of a downloaded file, reusing the checksum computed while downloading
+func fileChecksum(img model.ImageInfo) (int64, string, error) {
+	if img.Checksum == "" {
+		return hashFile(img.Path)
+	}
+	info, err := os.Stat(img.Path)
+	if err != nil {
+		return 0, "", err
+	}
+	return info.Size(), img.Checksum, nil
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkProfileIllusts asks Pixiv for the update dates of a batch of works,
+// so unchanged works can be skipped without requesting their detail
+func checkProfileIllusts(task *service.Task, c *colly.Collector, refs []model.WorkRef) {
+	for start := 0; start < len(refs); start += profileIllustsBatch {
+		end := min(start+profileIllustsBatch, len(refs))
+		batch := refs[start:end]
+
+		ids := make([]string, 0, len(batch))
+		for _, ref := range batch {
+			ids = append(ids, "ids[]="+ref.ID)
+		}
+		batchURL := fmt.Sprintf("https://www.pixiv.net/ajax/user/%s/profile/illusts?%s&work_category=illustManga&is_first_page=0",
+			task.UserInfo.UserID, strings.Join(ids, "&"))
+
+		ctx := colly.NewContext()
+		ctx.Put("refs", batch)
+		c.Request("GET", batchURL, nil, ctx, nil)
+	}
+}
+
+// handleProfileIllusts queues the works of a batch that are new or changed since they were archived
+func handleProfileIllusts(task *service.Task, c *colly.Collector, r *colly.Response) {
+	var resp struct {
+		Body struct {
+			Works map[string]struct {
+				UpdateDate string `json:"updateDate"`
+			} `json:"works"`
+		} `json:"body"`
+	}
+	refs, _ := r.Ctx.GetAny("refs").([]model.WorkRef)
+	if err := json.Unmarshal(r.Body, &resp); err != nil {
+		task.Logger.Error("Failed to parse profile illusts: %v", err)
+	}
+
+	for _, ref := range refs {
+		// Works missing from the batch response are requested anyway, the detail handler checks them again
+		if work, ok := resp.Body.Works[ref.ID]; ok {
+			ref.UpdateDate = work.UpdateDate
+		}
+		visitIllust(task, c, ref)
+	}
+}
+
+// archiveStatus compares a work against the index once its detail is known and counts it as new,
+// updated or skipped. Only tasks that download files use the index, everything else is "new"
+func archiveStatus(task *service.Task, illustID string, updateDate string) string {
+	if !downloadsImages(task) {
+		return "new"
+	}
+
+	status := openArchiveIndex(task.Dir).Status(illustID, updateDate)
+	switch status {
+	case "unchanged":
+		task.Logger.Info("Skipping illust %s, already archived", illustID)
+		task.CountWork("skipped")
+	case "updated":
+		task.Logger.Info("Illust %s changed since it was archived", illustID)
+		task.CountWork("updated")
+	default:
+		task.CountWork("new")
+	}
+	return status
+}
+
+// recordArchived adds a work to the index once every one of its files was downloaded
+func recordArchived(task *service.Task, ctx *colly.Context, images []model.ImageInfo) {
+	if len(images) == 0 {
+		return
+	}
+	for _, img := range images {
+		if img.Status != "success" {
+			return
+		}
+	}
+
+	illustID := ctx.Get("illustID")
+	if err := openArchiveIndex(task.Dir).Record(illustID, ctx.Get("updateDate"), images); err != nil {
+		task.Logger.Error("Failed to index illust %s: %v", illustID, err)
+	}
+}
@@ -0,0 +1,163 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-crawler-client/internal/account"
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Error codes of tasks refused or failed because of their session
+const (
+	ErrCodeAuthExpired = "auth_expired" // the cookie is not, or no longer, logged in
+	ErrCodeR18Disabled = "r18_disabled" // the task wants R-18 works the account does not show
+)
+
+// sessionCheckInterval is the minimum time between two checks of a running task's session
+const sessionCheckInterval = 30 * time.Second
+
+// AuthError is a session that cannot run a task
+type AuthError struct {
+	Code    string
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return e.Message
+}
+
+// CheckAuth validates the cookie of a task of the given mode before it starts.
+// It returns an *AuthError when the cookie is logged out or lacks a setting the task needs
+func CheckAuth(mode string, cookie string, options model.TaskOptions) (model.AuthStatus, error) {
+	src, ok := SourceFor(mode)
+	if !ok {
+		return model.AuthStatus{}, fmt.Errorf("invalid mode: %s", mode)
+	}
+	return src.CheckAuth(mode, cookie, options)
+}
+
+// CheckCookie reports who a Pixiv cookie is logged in as, the accounts of the vault are Pixiv accounts
+func CheckCookie(cookie string) (model.AuthStatus, error) {
+	return pixiv.CheckAuth("", cookie, model.TaskOptions{})
+}
+
+// CheckImportedCookie validates cookies imported from a browser: the user of their session must have a
+// profile on /ajax/user/{id}, and the session must still be logged in as that user
+func CheckImportedCookie(cookie string) (model.AuthStatus, error) {
+	userID := account.SessionUserID(cookie)
+	if userID == "" {
+		return model.AuthStatus{}, &AuthError{Code: ErrCodeAuthExpired, Message: "the imported cookies have no logged in pixiv session (PHPSESSID)"}
+	}
+	name, err := pixiv.sessionProfile(cookie, userID)
+	if err != nil {
+		return model.AuthStatus{}, err
+	}
+
+	status, err := CheckCookie(cookie)
+	if err != nil {
+		return status, err
+	}
+	if status.UserID != userID {
+		return status, &AuthError{Code: ErrCodeAuthExpired, Message: fmt.Sprintf("the imported session of pixiv user %s is logged in as user %s", userID, status.UserID)}
+	}
+	if status.UserName == "" {
+		status.UserName = name
+	}
+	return status, nil
+}
+
+// CheckPool validates every account of a task before it starts, guest pools have nothing to check
+func CheckPool(mode string, pool *account.Pool, options model.TaskOptions) error {
+	for _, m := range pool.Members() {
+		_, err := CheckAuth(mode, m.Cookie, options)
+		var authErr *AuthError
+		if errors.As(err, &authErr) && m.Alias != "" {
+			return &AuthError{Code: authErr.Code, Message: fmt.Sprintf("account %s: %s", m.Alias, authErr.Message)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lostResponses holds the responses found logged out by a running crawler, until their request is retried
+var lostResponses sync.Map // *colly.Response -> struct{}
+
+// onResponse registers a response handler of a source. Responses of a lost session are skipped: they carry
+// no data, and the crawler sends their request again with another account
+func onResponse(c *colly.Collector, handle colly.ResponseCallback) {
+	c.OnResponse(func(r *colly.Response) {
+		if _, lost := lostResponses.Load(r); lost {
+			return
+		}
+		handle(r)
+	})
+}
+
+// sessionWatch confirms the account of a response that looks logged out. A lost account leaves the pool
+// of the task, and the task fails with the code of the AuthError once no account is left
+type sessionWatch struct {
+	task *service.Task
+	src  Source
+	pool *account.Pool
+
+	mu        sync.Mutex
+	checkedAt map[string]time.Time // by account alias
+}
+
+func newSessionWatch(task *service.Task, src Source, pool *account.Pool) *sessionWatch {
+	return &sessionWatch{task: task, src: src, pool: pool, checkedAt: make(map[string]time.Time)}
+}
+
+// confirm checks an account again, at most once per sessionCheckInterval, and reports whether it was lost
+func (w *sessionWatch) confirm(alias string) bool {
+	// A guest task has no session to lose
+	if w.pool.Guest() {
+		return false
+	}
+	var member *account.Member
+	for _, m := range w.pool.Members() {
+		if m.Alias == alias {
+			member = &m
+			break
+		}
+	}
+	// Dropped by an earlier response
+	if member == nil {
+		return true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.task.Context().Err() != nil {
+		return true
+	}
+	if time.Since(w.checkedAt[alias]) < sessionCheckInterval {
+		return false
+	}
+	w.checkedAt[alias] = time.Now()
+
+	w.task.Logger.Warn("Response looks logged out, checking the session")
+	_, err := w.src.CheckAuth(w.task.Mode, member.Cookie, w.task.Options)
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		if err != nil {
+			w.task.Logger.Warn("Failed to check the session: %v", err)
+		}
+		return false
+	}
+
+	if left := w.pool.Drop(alias); left > 0 {
+		w.task.Logger.Warn("Account %s dropped (%s): %s, continuing with %d accounts", alias, authErr.Code, authErr.Message, left)
+		return true
+	}
+	w.task.Fail(authErr.Code, authErr.Message)
+	return true
+}
@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+var bookmarksPattern = regexp.MustCompile(`^/ajax/user/(\d+)/illusts/bookmarks$`)
+
+// bookmarksPageSize is the largest page the bookmarks endpoint accepts
+const bookmarksPageSize = 48
+
+// bookmarksURL builds the URL of one page of a user's illust bookmarks
+func bookmarksURL(task *service.Task, offset int) string {
+	rest := "show"
+	tag := ""
+	if opts := task.Options.Bookmarks; opts != nil {
+		if opts.Visibility == "private" {
+			rest = "hide"
+		}
+		tag = opts.Tag
+	}
+
+	q := url.Values{}
+	q.Set("tag", tag)
+	q.Set("offset", strconv.Itoa(offset))
+	q.Set("limit", strconv.Itoa(bookmarksPageSize))
+	q.Set("rest", rest)
+	return fmt.Sprintf("https://www.pixiv.net/ajax/user/%s/illusts/bookmarks?%s", task.UserInfo.UserID, q.Encode())
+}
+
+// handleBookmarksPage queues every bookmarked work of a page and then the next page
+func handleBookmarksPage(task *service.Task, c *colly.Collector, r *colly.Response) {
+	var resp struct {
+		Body struct {
+			Works []struct {
+				Id         json.Number `json:"id"`
+				UpdateDate string      `json:"updateDate"`
+			} `json:"works"`
+			Total int `json:"total"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(r.Body, &resp); err != nil {
+		task.Logger.Error("Failed to parse bookmarks: %v", err)
+		return
+	}
+
+	offset, _ := strconv.Atoi(r.Request.URL.Query().Get("offset"))
+	task.Logger.Info("Found %d bookmarks (%d-%d of %d)", len(resp.Body.Works), offset, offset+len(resp.Body.Works), resp.Body.Total)
+
+	for i, work := range resp.Body.Works {
+		if listingFull(task, offset+i) {
+			return
+		}
+		// Category is left to the detail handler, bookmarks mix illusts and manga
+		visitIllust(task, c, model.WorkRef{ID: work.Id.String(), Kind: "illust", UpdateDate: work.UpdateDate})
+	}
+
+	next := offset + len(resp.Body.Works)
+	if len(resp.Body.Works) > 0 && next < resp.Body.Total {
+		r.Request.Visit(bookmarksURL(task, next))
+		return
+	}
+	task.SetListingDone()
+}
@@ -1,93 +1,77 @@
 package crawler
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"go-crawler-client/config"
+	"go-crawler-client/internal/account"
 	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/pkg/retry"
 	"go-crawler-client/internal/service"
 
 	"github.com/gocolly/colly/v2"
 )
 
-// GetUserInfo get the user info (sync)
-func GetUserInfo(userID string, cookie string) (model.UserInfo, error) {
-	// Check if proxy is configured
-	client := &http.Client{}
-	if config.GlobalConfig.ProxyHost != "" {
-		proxyURL, err := url.Parse(fmt.Sprintf("http://%s:%d", config.GlobalConfig.ProxyHost, config.GlobalConfig.ProxyPort))
-		if err == nil {
-			client.Transport = &http.Transport{
-				Proxy: http.ProxyURL(proxyURL),
-			}
-		}
-	}
-
-	// Request Pixiv API
-	apiURL := fmt.Sprintf("https://www.pixiv.net/ajax/user/%s", userID)
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return model.UserInfo{}, err
-	}
+// crawlParallelism is the number of requests a task runs at once
+const crawlParallelism = 5
 
-	req.Header.Set("Cookie", cookie)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+// IsSupportedMode reports whether a task mode can be handled by the crawler, i.e. some source registered it
+func IsSupportedMode(mode string) bool {
+	_, ok := SourceFor(mode)
+	return ok
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return model.UserInfo{}, err
-	}
-	defer resp.Body.Close()
+// RequiresUser reports whether a task mode starts from a user of its site
+func RequiresUser(mode string) bool {
+	src, ok := SourceFor(mode)
+	return ok && src.RequiresUser(mode)
+}
 
-	if resp.StatusCode != 200 {
-		return model.UserInfo{}, fmt.Errorf("API returned status: %d", resp.StatusCode)
+// ValidateTask checks that a task has everything its mode needs before it is created
+func ValidateTask(mode string, pixivUserID string, options model.TaskOptions) error {
+	src, ok := SourceFor(mode)
+	if !ok {
+		return fmt.Errorf("invalid mode: %s", mode)
 	}
-
-	var apiResp struct {
-		Body struct {
-			UserID   string `json:"userId"`
-			Name     string `json:"name"`
-			ImageBig string `json:"imageBig"`
-		} `json:"body"`
+	if src.RequiresUser(mode) && pixivUserID == "" {
+		return errors.New("missing required field: pixiv_user_id")
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return model.UserInfo{}, err
+	if err := validateFilter(options.Filter); err != nil {
+		return err
 	}
-
-	// Download avatar
-	baseDir := config.GetBaseDir()
-	avatarDir := filepath.Join(baseDir, "crawl-datas", userID, ".avatars")
-	if _, err := os.Stat(avatarDir); os.IsNotExist(err) {
-		os.MkdirAll(avatarDir, 0755)
+	tmpl := options.FilenameTemplate
+	if tmpl == "" {
+		tmpl = config.GlobalConfig.FilenameTemplate
 	}
-	avatarPath := filepath.Join(avatarDir, userID+".jpg")
-	// Download with Referer
-	err = downloadFileWithReferer(apiResp.Body.ImageBig, avatarPath, "https://www.pixiv.net/")
-	if err != nil {
-		// Log error but do not interrupt the process
-		fmt.Printf("Warning: failed to download avatar: %v\n", err)
+	if err := validateFilenameTemplate(tmpl); err != nil {
+		return err
 	}
+	return src.Validate(mode, pixivUserID, options)
+}
 
-	return model.UserInfo{
-		UserID:     apiResp.Body.UserID,
-		Name:       apiResp.Body.Name,
-		AvatarURL:  apiResp.Body.ImageBig,
-		AvatarPath: avatarPath,
-		Premium:    false,
-	}, nil
+// ResolveUser gets the info of the user a task of the given mode targets (sync), with the accounts of the task
+func ResolveUser(mode string, userID string, pool *account.Pool) (model.UserInfo, error) {
+	src, ok := SourceFor(mode)
+	if !ok {
+		return model.UserInfo{}, fmt.Errorf("invalid mode: %s", mode)
+	}
+	return src.ResolveUser(account.WithPool(context.Background(), pool), userID)
 }
 
-// StartCrawler starts the crawling process for a given task
-func StartCrawler(task *service.Task, cookie string) {
+// StartCrawler starts the crawling process for a given task, its requests take turns among the accounts of pool
+func StartCrawler(task *service.Task, pool *account.Pool) {
 	// Crash recovery
 	// If there is a bug in the crawler code causing a Panic, this defer will catch it to prevent the entire program from crashing
 	// and mark the task status as failed
@@ -95,179 +79,297 @@ func StartCrawler(task *service.Task, cookie string) {
 		if r := recover(); r != nil {
 			task.Logger.Error("Crawler panicked: %v", r)
 			task.UpdateStatus("failed")
+			task.RemoveCheckpoint()
+			releaseClaims(task)
 		}
 	}()
 
-	task.Logger.Info("Starting spider for user %s with mode %s", task.UserInfo.UserID, task.Mode)
+	if task.Scope == "user" {
+		task.Logger.Info("Starting spider for user %s with mode %s", task.UserInfo.UserID, task.Mode)
+	} else {
+		task.Logger.Info("Starting spider for %s %q", task.Scope, task.Target)
+	}
+
+	src := sourceOf(task)
+	// The context of this run, a task resumed after failing gets a new one while this run winds down
+	ctx := task.Context()
 
 	// Initialize Colly collector
 	// colly.Async(true) enables asynchronous mode, allowing multiple requests to be sent in parallel
+	// colly.StdlibContext ties every request to the task, so cancelling the task aborts them
 	c := colly.NewCollector(
 		colly.Async(true),
+		colly.StdlibContext(ctx),
 	)
+	// The Cookie of the pool member is the only one sent: a shared jar would carry the cookies Pixiv sets
+	// for one account over to the requests of the others
+	c.DisableCookies()
 
-	// Set proxy (if configured)
-	if config.GlobalConfig.ProxyHost != "" {
-		proxyURL := fmt.Sprintf("http://%s:%d", config.GlobalConfig.ProxyHost, config.GlobalConfig.ProxyPort)
-		c.SetProxy(proxyURL)
-	}
+	// Proxy and the process-wide per-host rate limits, shared with every other task
+	c.WithTransport(httpTransport())
 
 	// Concurrency limit (very important!)
-	// Limit to a maximum of 5 concurrent requests, with a random delay between each request to prevent Pixiv from banning the IP
+	// Limit to a maximum of 5 concurrent requests per task, with a random delay between each request to prevent Pixiv from banning the IP.
+	// The shared transport caps the requests of all tasks together on top of this
 	c.Limit(&colly.LimitRule{
 		DomainGlob:  "*",
-		Parallelism: 5,
+		Parallelism: crawlParallelism,
 		RandomDelay: 1 * time.Second,
 	})
 
-	// Request callback: automatically add Cookie and User-Agent before each request
+	// Request callback: let the source add the Cookie and User-Agent before each request,
+	// with the next account of the pool that is not on cooldown
+	// Requests of a paused task wait here, requests of a cancelled task are dropped
 	c.OnRequest(func(r *colly.Request) {
-		r.Headers.Set("Cookie", cookie)
-		r.Headers.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
+		if err := task.WaitIfPaused(); err != nil {
+			r.Abort()
+			return
+		}
+		member, err := pool.Next(ctx)
+		if err != nil {
+			r.Abort()
+			return
+		}
+		src.PrepareRequest(r, member.Cookie)
+		account.Tag(*r.Headers, member.Alias)
+	})
+
+	// Responses that look logged out get their account checked again. A lost account leaves the pool and the
+	// request is retried with another one, once no account is left the task fails with auth_expired
+	// instead of completing with holes. The handlers of the source skip a lost response (see onResponse),
+	// it is retried once they all ran
+	session := newSessionWatch(task, src, pool)
+	c.OnResponse(func(r *colly.Response) {
+		if src.SessionLost(r) && session.confirm(account.Tagged(*r.Request.Headers)) {
+			lostResponses.Store(r, struct{}{})
+		}
 	})
 
 	// Error callback: log errors
 	c.OnError(func(r *colly.Response, err error) {
+		if ctx.Err() != nil {
+			return
+		}
+		if src.SessionLost(r) && session.confirm(account.Tagged(*r.Request.Headers)) {
+			if ctx.Err() == nil {
+				r.Request.Retry()
+			}
+			return
+		}
+		if retryRequest(task, r, err) {
+			return
+		}
 		task.Logger.Error("Request URL: %s failed with response: %v\nError: %v", r.Request.URL, r, err)
 	})
 
-	// 1. Handle Profile All (Get Illust IDs)
-	c.OnResponse(func(r *colly.Response) {
-		if strings.Contains(r.Request.URL.String(), "/profile/all") {
-			var resp struct {
-				Body struct {
-					Illusts map[string]any `json:"illusts"`
-				} `json:"body"`
-			}
-			if err := json.Unmarshal(r.Body, &resp); err != nil {
-				task.Logger.Error("Failed to parse profile: %v", err)
-				return
-			}
-
-			task.Logger.Info("Found %d illusts", len(resp.Body.Illusts))
+	// Listing, metadata and download handlers of the site
+	src.Attach(task, c)
 
-			for id := range resp.Body.Illusts {
-				detailURL := fmt.Sprintf("https://www.pixiv.net/ajax/illust/%s", id)
-				r.Request.Visit(detailURL)
-			}
+	// Registered last: every handler has seen the response before its request is sent again
+	c.OnResponse(func(r *colly.Response) {
+		if _, lost := lostResponses.LoadAndDelete(r); lost && ctx.Err() == nil {
+			r.Request.Retry()
 		}
 	})
 
-	// 2. Handle Illust Detail (Get Image URL)
-	c.OnResponse(func(r *colly.Response) {
-		if strings.Contains(r.Request.URL.String(), "/ajax/illust/") && !strings.Contains(r.Request.URL.String(), "profile") {
-			var resp struct {
-				Body struct {
-					Id       string `json:"id"`
-					Title    string `json:"title"`
-					UserName string `json:"userName"`
-					Urls     struct {
-						Original string `json:"original"`
-					} `json:"urls"`
-				} `json:"body"`
-			}
-			if err := json.Unmarshal(r.Body, &resp); err != nil {
-				return
-			}
+	// Continue with the works left over from before a restart
+	pending, listingDone := task.Frontier()
+	if len(pending) > 0 {
+		task.Logger.Info("Continuing with %d pending works", len(pending))
+	}
+	for _, ref := range pending {
+		src.Visit(task, c, ref)
+	}
+	task.Checkpoint(true)
 
-			if resp.Body.Urls.Original == "" {
-				return
-			}
+	// Start visiting, unless the whole listing was already known before a restart
+	if !listingDone {
+		src.List(task, c)
+	}
 
-			imgURL := resp.Body.Urls.Original
-			task.Logger.Info("Found image: %s", imgURL)
-
-			if task.Mode == "image" {
-				baseDir := config.GetBaseDir()
-				fileName := filepath.Base(imgURL)
-				savePath := filepath.Join(baseDir, "crawl-datas", task.UserInfo.UserID, ".download_imgs", fileName)
-
-				// Download with Referer
-				err := downloadFileWithReferer(imgURL, savePath, "https://www.pixiv.net/")
-				status := "success"
-				if err != nil {
-					status = "failed"
-					task.Logger.Error("Failed to download image %s: %v", imgURL, err)
-				} else {
-					task.Logger.Info("Downloaded image to %s", savePath)
-				}
-
-				task.AddImage(model.ImageInfo{
-					URL:      imgURL,
-					Path:     savePath,
-					Checksum: "",
-					Status:   status,
-				})
-			}
+	c.Wait()
 
-			task.AddResult(model.TaskResult{
-				UserID:    task.UserInfo.UserID,
-				UserName:  resp.Body.UserName,
-				ImageURLs: []string{imgURL},
-			})
-		}
-	})
+	// Matches held back by max_works are downloaded once every detail is known
+	if ctx.Err() == nil && releaseHeld(task) {
+		c.Wait()
+	}
 
-	// Start visiting
-	profileURL := fmt.Sprintf("https://www.pixiv.net/ajax/user/%s/profile/all", task.UserInfo.UserID)
-	c.Visit(profileURL)
+	if ctx.Err() != nil {
+		task.Logger.Info("Crawler stopped, saving partial results")
+	} else {
+		task.Logger.Info("Crawler finished")
+		task.UpdateStatus("completed")
+		if n := removeStaleParts(task.Dir); n > 0 {
+			task.Logger.Info("Removed %d stale partial downloads", n)
+		}
+	}
+	if downloadsImages(task) {
+		stats := task.GetSnapshot().Stats
+		task.Logger.Info("Works: %d new, %d updated, %d skipped", stats.New, stats.Updated, stats.Skipped)
+	}
+	if task.Options.Filter != nil {
+		stats := task.GetSnapshot().Stats
+		task.Logger.Info("Filter: %d matched, filtered out %s", stats.Matched, formatFilterCounts(stats.Filtered))
+	}
 
-	c.Wait()
+	saveTaskData(task)
+	releaseClaims(task)
+	// A task failed by its session keeps its checkpoint, resume_task continues it once the account logs in again
+	if task.Resumable() {
+		task.Checkpoint(true)
+	} else {
+		task.RemoveCheckpoint()
+	}
+}
 
-	task.Logger.Info("Crawler finished")
-	task.UpdateStatus("completed")
+// addListing records the complete list of works of a task in its frontier
+func addListing(task *service.Task, refs []model.WorkRef) {
+	for _, ref := range refs {
+		task.AddPending(ref)
+	}
+	task.SetListingDone()
+}
 
-	saveTaskData(task)
+// downloadsImages reports whether the task mode saves image files, not just metadata
+func downloadsImages(task *service.Task) bool {
+	switch task.Mode {
+	case "image", "bookmarks", "search", "ranking":
+		return true
+	}
+	return false
 }
 
-func downloadFileWithReferer(url string, filepath string, referer string) error {
-	client := &http.Client{}
-	if config.GlobalConfig.ProxyHost != "" {
-		proxyURL, err := urlParse(fmt.Sprintf("http://%s:%d", config.GlobalConfig.ProxyHost, config.GlobalConfig.ProxyPort))
-		if err == nil {
-			client.Transport = &http.Transport{
-				Proxy: http.ProxyURL(proxyURL),
-			}
+// downloadIllustPage downloads a single page of an illust, named by the filename template, and records it in the task
+func downloadIllustPage(task *service.Task, ctx *colly.Context, page int, imgURL string) model.ImageInfo {
+	illustID := ctx.Get("illustID")
+	savePath := imagePath(task, ctx, page, "illust", strings.TrimPrefix(path.Ext(imgURL), "."))
+
+	// Downloaded before a restart
+	if img, ok := task.FindImage(savePath); ok {
+		if _, err := os.Stat(savePath); err == nil {
+			task.Logger.Info("Image %s already downloaded", savePath)
+			return img
 		}
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	if err := task.WaitIfPaused(); err != nil {
+		return model.ImageInfo{URL: imgURL, IllustID: illustID, Page: page, Status: "cancelled"}
+	}
+
+	// Download with Referer
+	checksum, attempts, err := downloadWithRetry(task, sourceOf(task).Download(imgURL), savePath)
+	if task.Context().Err() != nil {
+		return model.ImageInfo{URL: imgURL, IllustID: illustID, Page: page, Status: "cancelled"}
+	}
+	status := downloadStatus(err)
 	if err != nil {
-		return err
+		task.Logger.Error("Failed to download image %s: %v", imgURL, err)
+	} else {
+		task.Logger.Info("Downloaded image to %s", savePath)
+		if err := makePreviews(task.Dir, savePath, previewSizes...); err != nil {
+			task.Logger.Error("Failed to generate previews of %s: %v", savePath, err)
+		}
+	}
+
+	img := model.ImageInfo{
+		URL:      imgURL,
+		Path:     savePath,
+		IllustID: illustID,
+		Page:     page,
+		Kind:     "illust",
+		Checksum: checksum,
+		Status:   status,
+		Attempts: attempts,
+	}
+	task.AddImage(img)
+	releaseFailed(task, img)
+	return img
+}
+
+// downloadFile downloads a file with the headers of its source and returns its SHA-256, the request is aborted once ctx is done.
+// The body goes to a .part file that is fsynced and renamed into place once complete, so the final path
+// never holds a truncated file. An interrupted download keeps its .part and the next attempt resumes it
+// with a Range request. The body must match Content-Length and images must decode, otherwise errCorrupt is returned
+func downloadFile(ctx context.Context, dl DownloadRequest, filepath string) (string, error) {
+	client := httpClient()
+
+	partPath := filepath + partSuffix
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", dl.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, values := range dl.Header {
+		req.Header[key] = values
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
-	req.Header.Set("Referer", referer)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("status code: %d", resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range, start over
+		offset = 0
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			os.Remove(partPath)
+			return "", fmt.Errorf("%w: unexpected range %q", errCorrupt, resp.Header.Get("Content-Range"))
+		}
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The part no longer fits the file on the server
+		os.Remove(partPath)
+		return "", fmt.Errorf("%w: stale partial download", errCorrupt)
+	default:
+		return "", &statusError{Code: resp.StatusCode, RetryAfter: retry.ParseRetryAfter(resp.Header.Get("Retry-After"))}
 	}
 
-	out, err := os.Create(filepath)
+	// Hash while streaming, only a resumed part is read back
+	h := sha256.New()
+	out, err := openPart(partPath, offset, h)
 	if err != nil {
-		return err
+		return "", err
+	}
+	written, err := io.Copy(io.MultiWriter(out, h), resp.Body)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Keep what arrived, the next attempt resumes from there
+		return "", err
 	}
-	defer out.Close()
-
-	_, err = io.Copy(out, resp.Body)
-	return err
-}
 
-// Helper wrapper for url.Parse to avoid import conflict if any (though we imported net/url as url)
-// Actually we imported "net/url", so we should use url.Parse
-func urlParse(rawurl string) (*url.URL, error) {
-	return url.Parse(rawurl)
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		err = fmt.Errorf("%w: got %d of %d bytes", errCorrupt, written, resp.ContentLength)
+	}
+	if err == nil {
+		err = checkDecodable(partPath, path.Ext(filepath))
+	}
+	if err == nil {
+		err = os.Rename(partPath, filepath)
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // This function is responsible for writing the in-memory results to disk after the task is completed
 func saveTaskData(task *service.Task) {
-	baseDir := config.GetBaseDir()
-	userDir := filepath.Join(baseDir, "crawl-datas", task.UserInfo.UserID)
+	userDir := task.Dir
 
 	// Save task results
 	resultFile := filepath.Join(userDir, ".task_data", fmt.Sprintf("task_%s.jsonl", task.ID))
@@ -0,0 +1,244 @@
+package crawler
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go-crawler-client/internal/model"
+)
+
+// epubBook is everything needed to render a novel as EPUB
+type epubBook struct {
+	ID          string
+	Title       string
+	Author      string
+	Language    string
+	Description string
+	Date        string
+	Series      *model.SeriesInfo
+	Content     string // raw pixiv novel markup
+	Cover       []byte
+	CoverExt    string
+}
+
+type epubEntry struct {
+	name    string
+	content []byte
+}
+
+type epubSection struct {
+	Title string
+	Body  string // XHTML fragment
+}
+
+var (
+	novelChapterPattern = regexp.MustCompile(`^\[chapter:(.*)\]$`)
+	novelRubyPattern    = regexp.MustCompile(`\[\[rb:(.+?)\s*&gt;\s*(.+?)\]\]`)
+	novelJumpURIPattern = regexp.MustCompile(`\[\[jumpuri:(.+?)\s*&gt;\s*(.+?)\]\]`)
+	novelTagPattern     = regexp.MustCompile(`\[(pixivimage|uploadedimage|jump):[^\]]*\]`)
+)
+
+// writeEPUB renders a novel as an EPUB 3 file (with an NCX for older readers)
+func writeEPUB(path string, book epubBook) error {
+	if book.Language == "" {
+		book.Language = "ja"
+	}
+	sections := splitNovelSections(book)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	// The mimetype entry must come first and be stored uncompressed
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("application/epub+zip")); err != nil {
+		return err
+	}
+
+	entries := []epubEntry{
+		{"META-INF/container.xml", []byte(epubContainer)},
+		{"OEBPS/content.opf", []byte(renderEPUBPackage(book, sections))},
+		{"OEBPS/nav.xhtml", []byte(renderEPUBNav(book, sections))},
+		{"OEBPS/toc.ncx", []byte(renderEPUBNCX(book, sections))},
+	}
+	if len(book.Cover) > 0 {
+		coverPage := fmt.Sprintf(`<div class="cover"><img src="cover%s" alt="%s"/></div>`, book.CoverExt, html.EscapeString(book.Title))
+		entries = append(entries,
+			epubEntry{"OEBPS/cover" + book.CoverExt, book.Cover},
+			epubEntry{"OEBPS/cover.xhtml", []byte(renderEPUBPage(book, "Cover", coverPage))},
+		)
+	}
+	for i, sec := range sections {
+		name := fmt.Sprintf("OEBPS/section%03d.xhtml", i+1)
+		entries = append(entries, epubEntry{name, []byte(renderEPUBPage(book, sec.Title, sec.Body))})
+	}
+
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(e.content); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// splitNovelSections converts pixiv novel markup into XHTML sections, one per [newpage]
+func splitNovelSections(book epubBook) []epubSection {
+	pages := strings.Split(strings.ReplaceAll(book.Content, "\r\n", "\n"), "[newpage]")
+	sections := make([]epubSection, 0, len(pages))
+
+	for i, page := range pages {
+		sec := epubSection{Title: fmt.Sprintf("%d", i+1)}
+		if len(pages) == 1 {
+			sec.Title = book.Title
+		}
+
+		var b strings.Builder
+		for _, line := range strings.Split(strings.Trim(page, "\n"), "\n") {
+			line = strings.TrimSpace(line)
+			if m := novelChapterPattern.FindStringSubmatch(line); m != nil {
+				sec.Title = m[1]
+				fmt.Fprintf(&b, "<h2>%s</h2>\n", html.EscapeString(m[1]))
+				continue
+			}
+			if line == "" {
+				b.WriteString("<p><br/></p>\n")
+				continue
+			}
+
+			text := html.EscapeString(line)
+			text = novelRubyPattern.ReplaceAllString(text, "<ruby>$1<rt>$2</rt></ruby>")
+			text = novelJumpURIPattern.ReplaceAllString(text, `<a href="$2">$1</a>`)
+			text = novelTagPattern.ReplaceAllString(text, "")
+			fmt.Fprintf(&b, "<p>%s</p>\n", text)
+		}
+		sec.Body = b.String()
+		sections = append(sections, sec)
+	}
+
+	return sections
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+func renderEPUBPackage(book epubBook, sections []epubSection) string {
+	var meta, manifest, spine strings.Builder
+
+	fmt.Fprintf(&meta, "    <dc:identifier id=\"book-id\">urn:pixiv:novel:%s</dc:identifier>\n", html.EscapeString(book.ID))
+	fmt.Fprintf(&meta, "    <dc:title>%s</dc:title>\n", html.EscapeString(book.Title))
+	fmt.Fprintf(&meta, "    <dc:creator>%s</dc:creator>\n", html.EscapeString(book.Author))
+	fmt.Fprintf(&meta, "    <dc:language>%s</dc:language>\n", html.EscapeString(book.Language))
+	if book.Description != "" {
+		fmt.Fprintf(&meta, "    <dc:description>%s</dc:description>\n", html.EscapeString(book.Description))
+	}
+	if book.Date != "" {
+		fmt.Fprintf(&meta, "    <dc:date>%s</dc:date>\n", html.EscapeString(book.Date))
+	}
+	fmt.Fprintf(&meta, "    <meta property=\"dcterms:modified\">%s</meta>\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	if book.Series != nil {
+		// EPUB 3 collection plus the calibre convention, which most readers understand
+		fmt.Fprintf(&meta, "    <meta property=\"belongs-to-collection\" id=\"series\">%s</meta>\n", html.EscapeString(book.Series.Title))
+		meta.WriteString("    <meta refines=\"#series\" property=\"collection-type\">series</meta>\n")
+		fmt.Fprintf(&meta, "    <meta refines=\"#series\" property=\"group-position\">%d</meta>\n", book.Series.Order)
+		fmt.Fprintf(&meta, "    <meta name=\"calibre:series\" content=\"%s\"/>\n", html.EscapeString(book.Series.Title))
+		fmt.Fprintf(&meta, "    <meta name=\"calibre:series_index\" content=\"%d\"/>\n", book.Series.Order)
+	}
+
+	manifest.WriteString("    <item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n")
+	manifest.WriteString("    <item id=\"ncx\" href=\"toc.ncx\" media-type=\"application/x-dtbncx+xml\"/>\n")
+	if len(book.Cover) > 0 {
+		meta.WriteString("    <meta name=\"cover\" content=\"cover-image\"/>\n")
+		fmt.Fprintf(&manifest, "    <item id=\"cover-image\" href=\"cover%s\" media-type=\"%s\" properties=\"cover-image\"/>\n", book.CoverExt, imageMediaType(book.CoverExt))
+		manifest.WriteString("    <item id=\"cover\" href=\"cover.xhtml\" media-type=\"application/xhtml+xml\"/>\n")
+		spine.WriteString("    <itemref idref=\"cover\"/>\n")
+	}
+	for i := range sections {
+		fmt.Fprintf(&manifest, "    <item id=\"section%03d\" href=\"section%03d.xhtml\" media-type=\"application/xhtml+xml\"/>\n", i+1, i+1)
+		fmt.Fprintf(&spine, "    <itemref idref=\"section%03d\"/>\n", i+1)
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+%s  </metadata>
+  <manifest>
+%s  </manifest>
+  <spine toc="ncx">
+%s  </spine>
+</package>
+`, meta.String(), manifest.String(), spine.String())
+}
+
+func renderEPUBNav(book epubBook, sections []epubSection) string {
+	var items strings.Builder
+	for i, sec := range sections {
+		fmt.Fprintf(&items, "      <li><a href=\"section%03d.xhtml\">%s</a></li>\n", i+1, html.EscapeString(sec.Title))
+	}
+	body := fmt.Sprintf("<nav epub:type=\"toc\" id=\"toc\">\n    <h1>%s</h1>\n    <ol>\n%s    </ol>\n  </nav>", html.EscapeString(book.Title), items.String())
+	return renderEPUBPage(book, book.Title, body)
+}
+
+func renderEPUBNCX(book epubBook, sections []epubSection) string {
+	var points strings.Builder
+	for i, sec := range sections {
+		fmt.Fprintf(&points, "    <navPoint id=\"section%03d\" playOrder=\"%d\">\n      <navLabel><text>%s</text></navLabel>\n      <content src=\"section%03d.xhtml\"/>\n    </navPoint>\n",
+			i+1, i+1, html.EscapeString(sec.Title), i+1)
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="urn:pixiv:novel:%s"/>
+  </head>
+  <docTitle><text>%s</text></docTitle>
+  <navMap>
+%s  </navMap>
+</ncx>
+`, html.EscapeString(book.ID), html.EscapeString(book.Title), points.String())
+}
+
+func renderEPUBPage(book epubBook, title string, body string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="%s">
+<head>
+  <title>%s</title>
+</head>
+<body>
+  %s
+</body>
+</html>
+`, html.EscapeString(book.Language), html.EscapeString(title), body)
+}
+
+func imageMediaType(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	default:
+		return "image/jpeg"
+	}
+}
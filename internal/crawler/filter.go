@@ -0,0 +1,285 @@
+package crawler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+)
+
+// workFacts is what the filter looks at, taken from an illust or novel detail
+type workFacts struct {
+	CreateDate string
+	Tags       []string
+	XRestrict  int // 0: all-ages, 1: R-18, 2: R-18G
+	AIType     int // 0: unknown, 1: not AI-generated, 2: AI-generated
+	Bookmarks  int
+	Likes      int
+	Type       string // illust, manga, ugoira, novel
+}
+
+var xRestrictNames = []string{"all-ages", "r18", "r18g"}
+
+var illustTypeNames = []string{"illust", "manga", "ugoira"}
+
+// validateFilter checks a filter spec before the task is created
+func validateFilter(spec *model.FilterSpec) error {
+	if spec == nil {
+		return nil
+	}
+	for _, date := range []string{spec.DateFrom, spec.DateTo} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return fmt.Errorf("invalid filter date %q, expected YYYY-MM-DD", date)
+		}
+	}
+	for _, v := range spec.XRestrict {
+		if !contains(xRestrictNames, v) {
+			return fmt.Errorf("invalid filter x_restrict: %s", v)
+		}
+	}
+	for _, v := range spec.IllustTypes {
+		if !contains(illustTypeNames, v) {
+			return fmt.Errorf("invalid filter illust type: %s", v)
+		}
+	}
+	if spec.AI != "" && spec.AI != "exclude" && spec.AI != "only" {
+		return fmt.Errorf("invalid filter ai: %s", spec.AI)
+	}
+	return nil
+}
+
+// filterReason returns why a work does not match the filter spec, or "" when it does
+func filterReason(spec *model.FilterSpec, facts workFacts) string {
+	if spec.DateFrom != "" || spec.DateTo != "" {
+		created, err := time.Parse(time.RFC3339, facts.CreateDate)
+		if err != nil {
+			return "date"
+		}
+		// Compare calendar days in the time zone Pixiv reports the date in
+		loc := created.Location()
+		if spec.DateFrom != "" {
+			from, _ := time.ParseInLocation("2006-01-02", spec.DateFrom, loc)
+			if created.Before(from) {
+				return "date"
+			}
+		}
+		if spec.DateTo != "" {
+			to, _ := time.ParseInLocation("2006-01-02", spec.DateTo, loc)
+			if !created.Before(to.AddDate(0, 0, 1)) {
+				return "date"
+			}
+		}
+	}
+
+	if len(spec.ExcludeTags) > 0 && hasAnyTag(facts.Tags, spec.ExcludeTags) {
+		return "excluded_tag"
+	}
+	if len(spec.IncludeTags) > 0 && !hasAnyTag(facts.Tags, spec.IncludeTags) {
+		return "missing_tag"
+	}
+
+	if len(spec.XRestrict) > 0 {
+		if facts.XRestrict < 0 || facts.XRestrict >= len(xRestrictNames) || !contains(spec.XRestrict, xRestrictNames[facts.XRestrict]) {
+			return "x_restrict"
+		}
+	}
+
+	switch spec.AI {
+	case "exclude":
+		if facts.AIType == 2 {
+			return "ai"
+		}
+	case "only":
+		if facts.AIType != 2 {
+			return "ai"
+		}
+	}
+
+	if facts.Bookmarks < spec.MinBookmarks {
+		return "bookmarks"
+	}
+	if facts.Likes < spec.MinLikes {
+		return "likes"
+	}
+
+	if len(spec.IllustTypes) > 0 && facts.Type != "novel" && !contains(spec.IllustTypes, facts.Type) {
+		return "illust_type"
+	}
+
+	return ""
+}
+
+// passesFilter applies the task filter to a work once its detail is known.
+// Works that do not match are counted with the reason and marked done. A match held back for max_works
+// is handled again by resume, called with the detail already parsed once the work is picked
+func passesFilter(task *service.Task, ref model.WorkRef, facts workFacts, resume func()) bool {
+	spec := task.Options.Filter
+	if spec == nil {
+		return true
+	}
+
+	reason := filterReason(spec, facts)
+	// Details arrive in any order: with max_works, matches are held back until every detail is known,
+	// and the newest ones downloaded then (see releaseHeld)
+	if reason == "" && spec.MaxWorks > 0 && filterNeedsDetail(spec) {
+		if task.IsSelected(ref.Key()) {
+			return true
+		}
+		task.HoldMatch(service.HeldWork{Ref: ref, Resume: resume})
+		return false
+	}
+	// Without other criteria the listing was cut to max_works already (see capListing)
+	if reason == "" && !task.AcceptWork(spec.MaxWorks) {
+		reason = "max_works"
+	}
+	if reason == "" {
+		return true
+	}
+
+	task.Logger.Info("Skipping %s %s: filtered by %s", ref.Kind, ref.ID, reason)
+	task.CountFiltered(reason)
+	task.MarkDone(ref.Key())
+	return false
+}
+
+// filterNeedsDetail reports whether the filter has criteria besides max_works, which only the detail of a work answers
+func filterNeedsDetail(spec *model.FilterSpec) bool {
+	return spec.DateFrom != "" || spec.DateTo != "" || len(spec.IncludeTags) > 0 || len(spec.ExcludeTags) > 0 ||
+		len(spec.XRestrict) > 0 || spec.AI != "" || spec.MinBookmarks > 0 || spec.MinLikes > 0 || len(spec.IllustTypes) > 0
+}
+
+// capsListing reports whether max_works applies to the listing itself: the filter has no other criteria,
+// so the first max_works works listed are the ones kept
+func capsListing(task *service.Task) bool {
+	return limitsWorks(task) && !filterNeedsDetail(task.Options.Filter)
+}
+
+// capListing keeps the first max_works works of a complete listing sorted newest first
+func capListing(task *service.Task, refs []model.WorkRef) []model.WorkRef {
+	max := task.Options.Filter.MaxWorks
+	if !capsListing(task) || len(refs) <= max {
+		return refs
+	}
+	task.Logger.Info("Keeping the newest %d of %d works (max_works)", max, len(refs))
+	for range refs[max:] {
+		task.CountFiltered("max_works")
+	}
+	return refs[:max]
+}
+
+// listingFull reports whether a paged listing has listed max_works works, and stops it when it has
+func listingFull(task *service.Task, listed int) bool {
+	if !capsListing(task) || listed < task.Options.Filter.MaxWorks {
+		return false
+	}
+	task.Logger.Info("Reached max works (%d), stopping the listing", task.Options.Filter.MaxWorks)
+	task.SetListingDone()
+	return true
+}
+
+// releaseHeld picks the newest max_works matches held back by passesFilter and downloads them from their
+// parsed details, at most crawlParallelism at a time. The other matches are filtered out.
+// It reports whether any work was picked, multi-page works and ugoira queue requests the caller waits for
+func releaseHeld(task *service.Task) bool {
+	held := task.TakeHeld()
+	if len(held) == 0 {
+		return false
+	}
+	task.Logger.Info("Picking the newest %d of %d matching works", task.Options.Filter.MaxWorks, len(held))
+	sort.Slice(held, func(i, j int) bool { return newerThan(held[i].Ref, held[j].Ref) })
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, crawlParallelism)
+	picked := false
+	for _, work := range held {
+		ref := work.Ref
+		if !task.AcceptWork(task.Options.Filter.MaxWorks) {
+			task.Logger.Info("Skipping %s %s: filtered by max_works", ref.Kind, ref.ID)
+			task.CountFiltered("max_works")
+			task.MarkDone(ref.Key())
+			continue
+		}
+		task.Select(ref.Key())
+		picked = true
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			work.Resume()
+		}()
+	}
+	wg.Wait()
+	return picked
+}
+
+// illustTypeName maps an illustType to its filter name
+func illustTypeName(illustType int) string {
+	if illustType >= 0 && illustType < len(illustTypeNames) {
+		return illustTypeNames[illustType]
+	}
+	return illustTypeNames[0]
+}
+
+// limitsWorks reports whether the task caps its works, in which case archived works still
+// go through the filter so that they take their place among the newest ones
+func limitsWorks(task *service.Task) bool {
+	return task.Options.Filter != nil && task.Options.Filter.MaxWorks > 0
+}
+
+// sortNewestFirst orders listed works by ID, newest first
+func sortNewestFirst(refs []model.WorkRef) {
+	sort.Slice(refs, func(i, j int) bool { return newerThan(refs[i], refs[j]) })
+}
+
+// newerThan compares works by ID, later works have larger IDs
+func newerThan(a, b model.WorkRef) bool {
+	if len(a.ID) != len(b.ID) {
+		return len(a.ID) > len(b.ID)
+	}
+	return a.ID > b.ID
+}
+
+// formatFilterCounts renders filtered counts as "reason: n" pairs in a stable order
+func formatFilterCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	parts := make([]string, 0, len(reasons))
+	for _, reason := range reasons {
+		parts = append(parts, fmt.Sprintf("%s: %d", reason, counts[reason]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func hasAnyTag(tags []string, wanted []string) bool {
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if strings.EqualFold(tag, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
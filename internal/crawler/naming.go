@@ -0,0 +1,285 @@
+package crawler
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-crawler-client/config"
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/pkg/fsutil"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+var (
+	placeholderPattern  = regexp.MustCompile(`\{([a-z_]+)\}`)
+	legacyPagePattern   = regexp.MustCompile(`^(\d+)_p(\d+)\.\w+$`)
+	legacyUgoiraPattern = regexp.MustCompile(`^(\d+)_(ugoira\.zip|frames\.json|ugoira\.gif)$`)
+)
+
+// templatePlaceholders are the names a filename template can use
+var templatePlaceholders = map[string]bool{
+	"user_id": true, "user_name": true, "illust_id": true, "title": true, "page": true, "ext": true,
+	"type": true, "category": true, "date": true, "year": true, "month": true, "day": true, "upload_date": true,
+	"tag": true, "tags": true, "series": true, "series_id": true, "series_order": true,
+	"rank": true, "rank_date": true, "restriction": true,
+}
+
+// Paths claimed by the downloads of running tasks, absolute path -> pathClaim, so two works rendering to the
+// same name never share a file. A claim lasts until its download fails or its task ends, by then the file is
+// on disk and in the archive index, which claimPath checks as well
+var claimedPaths sync.Map
+
+type pathClaim struct {
+	owner  string // "illustID:page:kind"
+	taskID string
+}
+
+// filenameTemplate returns the template of a task, falling back to the config. Empty means legacy names
+func filenameTemplate(task *service.Task) string {
+	if task.Options.FilenameTemplate != "" {
+		return task.Options.FilenameTemplate
+	}
+	return config.GlobalConfig.FilenameTemplate
+}
+
+// validateFilenameTemplate checks a template before the task is created
+func validateFilenameTemplate(tmpl string) error {
+	if tmpl == "" {
+		return nil
+	}
+	if strings.HasPrefix(tmpl, "/") || strings.Contains(tmpl, "\\") || filepath.IsAbs(tmpl) {
+		return fmt.Errorf("filename template must be a relative path using /: %s", tmpl)
+	}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(tmpl, -1) {
+		if !templatePlaceholders[match[1]] {
+			return fmt.Errorf("unknown filename template placeholder: {%s}", match[1])
+		}
+	}
+	if !strings.Contains(tmpl, "{illust_id}") && !strings.Contains(tmpl, "{title}") {
+		return fmt.Errorf("filename template needs {illust_id} or {title}: %s", tmpl)
+	}
+	return nil
+}
+
+// imagePath returns where a file of an illust is saved under .download_imgs, ext without the dot.
+// Existing files of other works are never overwritten, a " (n)" suffix is added instead
+func imagePath(task *service.Task, ctx *colly.Context, page int, kind string, ext string) string {
+	illustID := ctx.Get("illustID")
+	imgDir := filepath.Join(task.Dir, ".download_imgs")
+
+	tmpl := filenameTemplate(task)
+	if tmpl == "" {
+		return filepath.Join(imgDir, legacyName(illustID, page, kind, ext))
+	}
+
+	// Downloaded before a restart
+	if img, ok := task.FindImageOf(illustID, page, kind); ok {
+		return img.Path
+	}
+
+	// Ugoira files share the page 0 name, told apart by their extension
+	switch kind {
+	case "ugoira_zip":
+		ext = "zip"
+	case "ugoira_frames":
+		ext = "frames.json"
+	}
+	rel := renderTemplate(tmpl, templateValues(task, ctx, page, ext))
+	return claimPath(task, imgDir, rel, illustID, claimOwner(illustID, page, kind))
+}
+
+func claimOwner(illustID string, page int, kind string) string {
+	return fmt.Sprintf("%s:%d:%s", illustID, page, kind)
+}
+
+// legacyName is the flat file name used when no template is configured
+func legacyName(illustID string, page int, kind string, ext string) string {
+	switch kind {
+	case "ugoira_zip":
+		return illustID + "_ugoira.zip"
+	case "ugoira_frames":
+		return illustID + "_frames.json"
+	case "ugoira":
+		return illustID + "_ugoira.gif"
+	}
+	return fmt.Sprintf("%s_p%d.%s", illustID, page, ext)
+}
+
+// renderTemplate fills in the placeholders of a template and sanitizes every path component
+func renderTemplate(tmpl string, values map[string]string) string {
+	parts := make([]string, 0, strings.Count(tmpl, "/")+1)
+	for _, part := range strings.Split(tmpl, "/") {
+		rendered := placeholderPattern.ReplaceAllStringFunc(part, func(m string) string {
+			// Values never add path components, the component as a whole is sanitized below
+			return strings.NewReplacer("/", "_", "\\", "_").Replace(values[m[1:len(m)-1]])
+		})
+		if strings.TrimSpace(rendered) == "" {
+			continue
+		}
+		parts = append(parts, fsutil.SanitizeName(rendered))
+	}
+	if len(parts) == 0 {
+		return "_"
+	}
+	return path.Join(parts...)
+}
+
+// templateValues collects the placeholder values of an illust from its request context
+func templateValues(task *service.Task, ctx *colly.Context, page int, ext string) map[string]string {
+	values := map[string]string{
+		"user_id":   ctx.Get("userID"),
+		"user_name": ctx.Get("userName"),
+		"illust_id": ctx.Get("illustID"),
+		"title":     ctx.Get("title"),
+		"page":      strconv.Itoa(page),
+		"ext":       ext,
+		"category":  ctx.Get("category"),
+		"rank_date": ctx.Get("rankDate"),
+	}
+	if values["user_id"] == "" {
+		values["user_id"] = task.UserInfo.UserID
+	}
+	if rank, ok := ctx.GetAny("rank").(int); ok && rank > 0 {
+		values["rank"] = strconv.Itoa(rank)
+	}
+	if series, ok := ctx.GetAny("series").(*model.SeriesInfo); ok && series != nil {
+		values["series"] = series.Title
+		values["series_id"] = series.ID
+		values["series_order"] = strconv.Itoa(series.Order)
+	}
+
+	work, _ := ctx.GetAny("work").(*model.Work)
+	if work == nil {
+		return values
+	}
+	values["type"] = work.Type
+	if work.XRestrict >= 0 && work.XRestrict < len(xRestrictNames) {
+		values["restriction"] = xRestrictNames[work.XRestrict]
+	}
+	if created, err := time.Parse(time.RFC3339, work.CreateDate); err == nil {
+		values["date"] = created.Format("2006-01-02")
+		values["year"] = created.Format("2006")
+		values["month"] = created.Format("01")
+		values["day"] = created.Format("02")
+	}
+	if uploaded, err := time.Parse(time.RFC3339, work.UploadDate); err == nil {
+		values["upload_date"] = uploaded.Format("2006-01-02")
+	}
+	if len(work.Tags) > 0 {
+		values["tag"] = work.Tags[0].Tag
+		tags := make([]string, 0, 3)
+		for _, tag := range work.Tags[:min(3, len(work.Tags))] {
+			tags = append(tags, tag.Tag)
+		}
+		values["tags"] = strings.Join(tags, ",")
+	}
+	return values
+}
+
+// claimPath reserves rel (relative to imgDir) for a file of a work, adding " (2)", " (3)"... when the name
+// is taken by another file of this process or by a file the archive index attributes to another work
+func claimPath(task *service.Task, imgDir string, rel string, illustID string, owner string) string {
+	ext := path.Ext(rel)
+	if strings.HasSuffix(rel, ".frames.json") {
+		ext = ".frames.json"
+	}
+	stem := strings.TrimSuffix(rel, ext)
+	index := openArchiveIndex(task.Dir)
+	claim := pathClaim{owner: owner, taskID: task.ID}
+
+	for n := 1; ; n++ {
+		candidate := rel
+		if n > 1 {
+			candidate = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		}
+		abs := filepath.Join(imgDir, filepath.FromSlash(candidate))
+
+		if prev, loaded := claimedPaths.LoadOrStore(abs, claim); loaded && prev.(pathClaim).owner != owner {
+			continue
+		}
+		if _, err := os.Stat(abs); err == nil {
+			relToTask := path.Join(".download_imgs", candidate)
+			if id, ok := index.Owner(relToTask); ok && id != illustID {
+				claimedPaths.CompareAndDelete(abs, claim)
+				continue
+			}
+		}
+		os.MkdirAll(filepath.Dir(abs), 0755)
+		return abs
+	}
+}
+
+// releaseFailed gives up the paths of files that failed to download, their names go to the next work asking
+func releaseFailed(task *service.Task, images ...model.ImageInfo) {
+	for _, img := range images {
+		if img.Status != "success" && img.Path != "" {
+			claimedPaths.CompareAndDelete(img.Path, pathClaim{owner: claimOwner(img.IllustID, img.Page, img.Kind), taskID: task.ID})
+		}
+	}
+}
+
+// releaseClaims gives up every path claimed by a task that ended
+func releaseClaims(task *service.Task) {
+	claimedPaths.Range(func(abs, claim any) bool {
+		if claim.(pathClaim).taskID == task.ID {
+			claimedPaths.CompareAndDelete(abs, claim)
+		}
+		return true
+	})
+}
+
+// FindImageFile locates a downloaded file of a task directory (crawl-datas/<uid> for a user) by the name
+// the backend knows it by: its path under .download_imgs, its file name in the archive index, or its legacy
+// {illust_id}_p{page}.{ext} name, which keeps working whatever template the file was saved with
+func FindImageFile(dir string, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file name: %q", name)
+	}
+
+	direct := filepath.Join(dir, ".download_imgs", clean)
+	if _, err := os.Stat(direct); err == nil {
+		return direct, nil
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, ".index", "archive.jsonl")); err != nil {
+		return "", os.ErrNotExist
+	}
+	index := openArchiveIndex(dir)
+	if file, ok := index.FindFile(name); ok {
+		return existingFile(filepath.Join(dir, filepath.FromSlash(file.Path)))
+	}
+
+	illustID, page, kind := "", 0, ""
+	if m := legacyPagePattern.FindStringSubmatch(name); m != nil {
+		illustID, kind = m[1], "illust"
+		page, _ = strconv.Atoi(m[2])
+	} else if m := legacyUgoiraPattern.FindStringSubmatch(name); m != nil {
+		illustID = m[1]
+		kind = map[string]string{"ugoira.zip": "ugoira_zip", "frames.json": "ugoira_frames", "ugoira.gif": "ugoira"}[m[2]]
+	}
+	if work, ok := index.Lookup(illustID); ok {
+		for _, file := range work.Files {
+			if file.Page == page && file.Kind == kind {
+				return existingFile(filepath.Join(dir, filepath.FromSlash(file.Path)))
+			}
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+func existingFile(p string) (string, error) {
+	if _, err := os.Stat(p); err != nil {
+		return "", err
+	}
+	return p, nil
+}
@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+)
+
+var novelDetailPattern = regexp.MustCompile(`^/ajax/novel/(\d+)$`)
+
+// handleNovelDetail saves a novel as plain text and EPUB under the user's .download_novels directory
+func handleNovelDetail(task *service.Task, body []byte) {
+	var resp struct {
+		Body struct {
+			workDetail
+			Content   string `json:"content"`
+			CoverUrl  string `json:"coverUrl"`
+			Language  string `json:"language"`
+			TextCount int    `json:"textCount"`
+			WordCount int    `json:"wordCount"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		task.Logger.Error("Failed to parse novel: %v", err)
+		return
+	}
+
+	novel := resp.Body
+	if novel.Id == "" {
+		return
+	}
+	task.Logger.Info("Found novel: %s (%s)", novel.Title, novel.Id)
+
+	resume := func() { handleNovelDetail(task, body) }
+	if !passesFilter(task, model.WorkRef{ID: novel.Id, Kind: "novel"}, novel.Facts("novel"), resume) {
+		return
+	}
+
+	series := novel.Series()
+	work := novel.Work("novel", "novel")
+	work.TextCount = novel.TextCount
+	work.WordCount = novel.WordCount
+	work.Language = novel.Language
+
+	novelDir := filepath.Join(task.Dir, ".download_novels")
+	files := make([]string, 0, 2)
+
+	// 1. Plain text
+	txtPath := filepath.Join(novelDir, novel.Id+".txt")
+	if err := os.WriteFile(txtPath, []byte(novel.Content), 0644); err != nil {
+		task.Logger.Error("Failed to save novel %s: %v", novel.Id, err)
+	} else {
+		files = append(files, txtPath)
+		task.Logger.Info("Saved novel text to %s", txtPath)
+	}
+
+	// 2. Cover (optional, the EPUB is still generated without it)
+	var cover []byte
+	coverExt := ""
+	if novel.CoverUrl != "" {
+		coverExt = path.Ext(novel.CoverUrl)
+		coverPath := filepath.Join(novelDir, novel.Id+"_cover"+coverExt)
+		if _, _, err := downloadWithRetry(task, sourceOf(task).Download(novel.CoverUrl), coverPath); err != nil {
+			task.Logger.Error("Failed to download novel cover %s: %v", novel.CoverUrl, err)
+		} else if data, err := os.ReadFile(coverPath); err == nil {
+			cover = data
+		}
+	}
+
+	if task.Context().Err() != nil {
+		return
+	}
+
+	// 3. EPUB
+	epubPath := filepath.Join(novelDir, novel.Id+".epub")
+	book := epubBook{
+		ID:          novel.Id,
+		Title:       novel.Title,
+		Author:      novel.UserName,
+		Language:    novel.Language,
+		Description: novel.Description,
+		Date:        novel.CreateDate,
+		Series:      series,
+		Content:     novel.Content,
+		Cover:       cover,
+		CoverExt:    coverExt,
+	}
+	if err := writeEPUB(epubPath, book); err != nil {
+		task.Logger.Error("Failed to generate EPUB for novel %s: %v", novel.Id, err)
+	} else {
+		files = append(files, epubPath)
+		task.Logger.Info("Generated EPUB %s", epubPath)
+	}
+
+	imageURLs := []string{}
+	if novel.CoverUrl != "" {
+		imageURLs = append(imageURLs, novel.CoverUrl)
+	}
+
+	task.AddResult(model.TaskResult{
+		UserID:    novel.UserId,
+		UserName:  novel.UserName,
+		ImageURLs: imageURLs,
+		WorkID:    novel.Id,
+		Title:     novel.Title,
+		Category:  "novel",
+		Series:    series,
+		Files:     files,
+		Work:      work,
+	})
+	task.MarkDone(model.WorkRef{ID: novel.Id, Kind: "novel"}.Key())
+}
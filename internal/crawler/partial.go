@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// partSuffix marks a download in progress, it is renamed to the final name once complete
+const partSuffix = ".part"
+
+// stalePartAge is how long a .part file can sit unchanged before a finished task removes it
+const stalePartAge = time.Hour
+
+// openPart opens the .part file of a download. When resuming at offset, the bytes already
+// on disk are fed to h first so the checksum covers the whole file
+func openPart(partPath string, offset int64, h hash.Hash) (*os.File, error) {
+	if offset == 0 {
+		return os.Create(partPath)
+	}
+
+	f, err := os.OpenFile(partPath, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(h, f, offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// contentRangeStart reads the first byte position of a "bytes start-end/total" Content-Range header
+func contentRangeStart(value string) (int64, bool) {
+	spec, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	startStr, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	return start, err == nil
+}
+
+// removeStaleParts deletes the .part files of a task directory that have not been written to
+// for stalePartAge, so downloads still running in other tasks on the same directory are left alone.
+// It returns how many were removed
+func removeStaleParts(dir string) int {
+	removed := 0
+	for _, sub := range []string{".download_imgs", ".download_novels", ".avatars"} {
+		filepath.WalkDir(filepath.Join(dir, sub), func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !strings.HasSuffix(p, partSuffix) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || time.Since(info.ModTime()) < stalePartAge {
+				return nil
+			}
+			if os.Remove(p) == nil {
+				removed++
+			}
+			return nil
+		})
+	}
+	return removed
+}
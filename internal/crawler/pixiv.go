@@ -0,0 +1,664 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go-crawler-client/config"
+	"go-crawler-client/internal/account"
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+const pixivUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
+
+var (
+	illustDetailPattern = regexp.MustCompile(`^/ajax/illust/(\d+)$`)
+	illustPagesPattern  = regexp.MustCompile(`^/ajax/illust/(\d+)/pages$`)
+)
+
+// pixivSource archives works of www.pixiv.net, with files served by i.pximg.net
+type pixivSource struct{}
+
+var pixiv pixivSource
+
+func init() {
+	RegisterSource(pixiv)
+}
+
+func (pixivSource) Name() string {
+	return "pixiv"
+}
+
+func (pixivSource) Modes() []string {
+	return []string{"image", "data", "novel", "bookmarks", "search", "ranking"}
+}
+
+func (pixivSource) RequiresUser(mode string) bool {
+	return mode != "search" && mode != "ranking"
+}
+
+func (pixivSource) Validate(mode string, userID string, options model.TaskOptions) error {
+	switch mode {
+	case "search":
+		return validateSearchOptions(options.Search)
+	case "ranking":
+		return validateRankingOptions(options.Ranking)
+	}
+	return nil
+}
+
+// ResolveUser gets the user info (sync) and downloads the avatar
+func (p pixivSource) ResolveUser(ctx context.Context, userID string) (model.UserInfo, error) {
+	client := httpClient()
+
+	// Request Pixiv API, the cookie comes from the account pool of ctx
+	apiURL := fmt.Sprintf("https://www.pixiv.net/ajax/user/%s", userID)
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+
+	req.Header.Set("User-Agent", pixivUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return model.UserInfo{}, fmt.Errorf("API returned status: %d", resp.StatusCode)
+	}
+
+	var apiResp struct {
+		Body struct {
+			UserID   string `json:"userId"`
+			Name     string `json:"name"`
+			ImageBig string `json:"imageBig"`
+		} `json:"body"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return model.UserInfo{}, err
+	}
+
+	// Download avatar
+	baseDir := config.GetBaseDir()
+	avatarDir := filepath.Join(baseDir, "crawl-datas", userID, ".avatars")
+	if _, err := os.Stat(avatarDir); os.IsNotExist(err) {
+		os.MkdirAll(avatarDir, 0755)
+	}
+	avatarPath := filepath.Join(avatarDir, userID+".jpg")
+	// The avatar comes from i.pximg.net, which gets no account
+	_, err = downloadFile(account.WithPool(ctx, nil), p.Download(apiResp.Body.ImageBig), avatarPath)
+	if err != nil {
+		// Log error but do not interrupt the process
+		log.Printf("Warning: failed to download avatar: %v", err)
+	}
+
+	return model.UserInfo{
+		UserID:     apiResp.Body.UserID,
+		Name:       apiResp.Body.Name,
+		AvatarURL:  apiResp.Body.ImageBig,
+		AvatarPath: avatarPath,
+		Premium:    false,
+	}, nil
+}
+
+// sessionProfile fetches the profile of the user a session cookie claims to belong to, and returns its name
+func (pixivSource) sessionProfile(cookie string, userID string) (string, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://www.pixiv.net/ajax/user/%s", userID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Cookie", cookie)
+	req.Header.Set("User-Agent", pixivUserAgent)
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var apiResp struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Body    struct {
+			UserID string `json:"userId"`
+			Name   string `json:"name"`
+		} `json:"body"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return "", fmt.Errorf("API returned status: %d", resp.StatusCode)
+	}
+	if resp.StatusCode != 200 || apiResp.Error {
+		return "", fmt.Errorf("pixiv user %s: %s (status %d)", userID, apiResp.Message, resp.StatusCode)
+	}
+	if apiResp.Body.UserID != userID {
+		return "", fmt.Errorf("pixiv user %s: profile of user %s returned", userID, apiResp.Body.UserID)
+	}
+	return apiResp.Body.Name, nil
+}
+
+// CheckAuth asks Pixiv who the cookie belongs to and which R-18 works the account shows
+func (pixivSource) CheckAuth(mode string, cookie string, options model.TaskOptions) (model.AuthStatus, error) {
+	req, err := http.NewRequest("GET", "https://www.pixiv.net/touch/ajax/user/self/status", nil)
+	if err != nil {
+		return model.AuthStatus{}, err
+	}
+	req.Header.Set("Cookie", cookie)
+	req.Header.Set("User-Agent", pixivUserAgent)
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return model.AuthStatus{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return model.AuthStatus{}, &AuthError{Code: ErrCodeAuthExpired, Message: "pixiv cookie is not logged in"}
+	}
+	if resp.StatusCode != 200 {
+		return model.AuthStatus{}, fmt.Errorf("API returned status: %d", resp.StatusCode)
+	}
+
+	var apiResp struct {
+		Body struct {
+			UserStatus struct {
+				// Numbers or strings depending on the endpoint version, null for guests
+				UserID    json.RawMessage `json:"user_id"`
+				UserName  string          `json:"user_name"`
+				IsPremium bool            `json:"is_premium"`
+				XRestrict json.RawMessage `json:"user_x_restrict"` // 0: all-ages only, 1: R-18, 2: R-18 and R-18G
+			} `json:"user_status"`
+		} `json:"body"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return model.AuthStatus{}, err
+	}
+
+	user := apiResp.Body.UserStatus
+	userID := rawScalar(user.UserID)
+	xRestrict, _ := strconv.Atoi(rawScalar(user.XRestrict))
+	status := model.AuthStatus{
+		LoggedIn: userID != "" && userID != "0",
+		UserID:   userID,
+		UserName: user.UserName,
+		Premium:  user.IsPremium,
+		R18:      xRestrict >= 1,
+		R18G:     xRestrict >= 2,
+	}
+	if !status.LoggedIn {
+		return status, &AuthError{Code: ErrCodeAuthExpired, Message: "pixiv cookie is not logged in"}
+	}
+
+	needR18, needR18G := pixivR18Needs(mode, options)
+	if needR18 && !status.R18 {
+		return status, &AuthError{Code: ErrCodeR18Disabled, Message: "R-18 works are disabled in the settings of pixiv account " + status.UserID}
+	}
+	if needR18G && !status.R18G {
+		return status, &AuthError{Code: ErrCodeR18Disabled, Message: "R-18G works are disabled in the settings of pixiv account " + status.UserID}
+	}
+	return status, nil
+}
+
+// rawScalar returns a JSON number or string as text, and "" for null
+func rawScalar(raw json.RawMessage) string {
+	if string(raw) == "null" {
+		return ""
+	}
+	return strings.Trim(string(raw), `"`)
+}
+
+// pixivR18Needs reports whether a task only asks for R-18 or R-18G works, which Pixiv hides
+// from accounts that did not enable them instead of returning an error
+func pixivR18Needs(mode string, options model.TaskOptions) (r18 bool, r18g bool) {
+	switch {
+	case mode == "search" && options.Search != nil:
+		r18 = options.Search.Rating == "r18"
+	case mode == "ranking" && options.Ranking != nil:
+		r18g = options.Ranking.Mode == "r18g"
+		r18 = r18g || strings.Contains(options.Ranking.Mode, "_r18")
+	}
+
+	if filter := options.Filter; filter != nil && len(filter.XRestrict) > 0 && !contains(filter.XRestrict, "all-ages") {
+		r18 = true
+		r18g = r18g || !contains(filter.XRestrict, "r18")
+	}
+	return r18, r18g
+}
+
+// SessionLost spots the signs of a logged out session: 401 answers, and R-18 works
+// whose detail comes without their files or text, which is what Pixiv sends to guests
+func (pixivSource) SessionLost(r *colly.Response) bool {
+	if r.StatusCode == http.StatusUnauthorized {
+		return true
+	}
+	path := r.Request.URL.Path
+	isIllust := illustDetailPattern.MatchString(path)
+	if !isIllust && !novelDetailPattern.MatchString(path) {
+		return false
+	}
+
+	var resp struct {
+		Error bool            `json:"error"`
+		Body  json.RawMessage `json:"body"` // an empty array along with errors
+	}
+	if err := json.Unmarshal(r.Body, &resp); err != nil {
+		return false
+	}
+	if resp.Error {
+		// Hidden works and deleted works look the same, CheckAuth tells them apart
+		return r.StatusCode == http.StatusForbidden || r.StatusCode == http.StatusBadRequest
+	}
+
+	var detail struct {
+		XRestrict int    `json:"xRestrict"`
+		Content   string `json:"content"`
+		Urls      struct {
+			Original string `json:"original"`
+		} `json:"urls"`
+	}
+	if err := json.Unmarshal(resp.Body, &detail); err != nil || detail.XRestrict == 0 {
+		return false
+	}
+	if isIllust {
+		return detail.Urls.Original == ""
+	}
+	return detail.Content == ""
+}
+
+func (pixivSource) PrepareRequest(r *colly.Request, cookie string) {
+	r.Headers.Set("Cookie", cookie)
+	r.Headers.Set("User-Agent", pixivUserAgent)
+}
+
+// Download sets the Referer, i.pximg.net refuses requests without one
+func (pixivSource) Download(url string) DownloadRequest {
+	header := make(http.Header)
+	header.Set("Referer", "https://www.pixiv.net/")
+	header.Set("User-Agent", pixivUserAgent)
+	return DownloadRequest{URL: url, Header: header}
+}
+
+func (pixivSource) List(task *service.Task, c *colly.Collector) {
+	switch task.Mode {
+	case "bookmarks":
+		c.Visit(bookmarksURL(task, 0))
+	case "search":
+		c.Visit(searchURL(task, 1))
+	case "ranking":
+		c.Visit(rankingURL(task, 1))
+	default:
+		profileURL := fmt.Sprintf("https://www.pixiv.net/ajax/user/%s/profile/all", task.UserInfo.UserID)
+		c.Visit(profileURL)
+	}
+}
+
+func (pixivSource) Visit(task *service.Task, c *colly.Collector, ref model.WorkRef) {
+	if ref.Kind == "novel" {
+		visitNovel(task, c, ref)
+	} else {
+		visitIllust(task, c, ref)
+	}
+}
+
+func (pixivSource) Attach(task *service.Task, c *colly.Collector) {
+	// 1. Handle Profile All (Get Illust and Manga IDs)
+	onResponse(c, func(r *colly.Response) {
+		if !strings.Contains(r.Request.URL.String(), "/profile/all") {
+			return
+		}
+		handleProfileAll(task, c, r)
+	})
+
+	// 2. Handle Illust Detail (Get Image URL)
+	onResponse(c, func(r *colly.Response) {
+		if !illustDetailPattern.MatchString(r.Request.URL.Path) {
+			return
+		}
+		handleIllustDetail(task, c, r)
+	})
+
+	// 3. Handle Illust Pages (Get every page of a multi-page work)
+	onResponse(c, func(r *colly.Response) {
+		m := illustPagesPattern.FindStringSubmatch(r.Request.URL.Path)
+		if m == nil {
+			return
+		}
+		handleIllustPages(task, m[1], r)
+	})
+
+	// 4. Handle Ugoira Meta (Get frame zip and delays)
+	onResponse(c, func(r *colly.Response) {
+		m := ugoiraMetaPattern.FindStringSubmatch(r.Request.URL.Path)
+		if m == nil {
+			return
+		}
+		handleUgoiraMeta(task, m[1], r.Ctx, r.Body)
+	})
+
+	// 5. Handle Novel Detail (Get text and metadata)
+	onResponse(c, func(r *colly.Response) {
+		if !novelDetailPattern.MatchString(r.Request.URL.Path) {
+			return
+		}
+		handleNovelDetail(task, r.Body)
+	})
+
+	// 6. Handle Bookmarks (Get bookmarked illust IDs page by page)
+	onResponse(c, func(r *colly.Response) {
+		if !bookmarksPattern.MatchString(r.Request.URL.Path) {
+			return
+		}
+		handleBookmarksPage(task, c, r)
+	})
+
+	// 7. Handle Search (Get matching illust IDs page by page)
+	onResponse(c, func(r *colly.Response) {
+		if !searchPattern.MatchString(r.Request.URL.Path) {
+			return
+		}
+		handleSearchPage(task, c, r)
+	})
+
+	// 8. Handle Ranking (Get ranked illust IDs page by page)
+	onResponse(c, func(r *colly.Response) {
+		if r.Request.URL.Path != "/ranking.php" {
+			return
+		}
+		handleRankingPage(task, c, r)
+	})
+
+	// 9. Handle Profile Illusts (Get update dates of archived works)
+	onResponse(c, func(r *colly.Response) {
+		if !profileIllustsPattern.MatchString(r.Request.URL.Path) {
+			return
+		}
+		handleProfileIllusts(task, c, r)
+	})
+}
+
+// handleProfileAll queues every work of the user, or only the novels of a novel task
+func handleProfileAll(task *service.Task, c *colly.Collector, r *colly.Response) {
+	var resp struct {
+		Body struct {
+			Illusts json.RawMessage `json:"illusts"`
+			Manga   json.RawMessage `json:"manga"`
+			Novels  json.RawMessage `json:"novels"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(r.Body, &resp); err != nil {
+		task.Logger.Error("Failed to parse profile: %v", err)
+		return
+	}
+
+	if task.Mode == "novel" {
+		novelIDs := profileWorkIDs(resp.Body.Novels)
+		task.Logger.Info("Found %d novels", len(novelIDs))
+		refs := make([]model.WorkRef, 0, len(novelIDs))
+		for _, id := range novelIDs {
+			refs = append(refs, model.WorkRef{ID: id, Kind: "novel"})
+		}
+		sortNewestFirst(refs)
+		refs = capListing(task, refs)
+		addListing(task, refs)
+		for _, ref := range refs {
+			visitNovel(task, c, ref)
+		}
+		return
+	}
+
+	illustIDs := profileWorkIDs(resp.Body.Illusts)
+	mangaIDs := profileWorkIDs(resp.Body.Manga)
+	task.Logger.Info("Found %d illusts and %d manga", len(illustIDs), len(mangaIDs))
+
+	refs := make([]model.WorkRef, 0, len(illustIDs)+len(mangaIDs))
+	for _, id := range illustIDs {
+		refs = append(refs, model.WorkRef{ID: id, Kind: "illust", Category: "illust"})
+	}
+	for _, id := range mangaIDs {
+		refs = append(refs, model.WorkRef{ID: id, Kind: "illust", Category: "manga"})
+	}
+
+	sortNewestFirst(refs)
+	refs = capListing(task, refs)
+	addListing(task, refs)
+
+	// With an existing archive, check update dates in batches before requesting any detail
+	if downloadsImages(task) && openArchiveIndex(task.Dir).Len() > 0 && !limitsWorks(task) {
+		checkProfileIllusts(task, c, refs)
+		return
+	}
+	for _, ref := range refs {
+		visitIllust(task, c, ref)
+	}
+}
+
+// handleIllustDetail reads the metadata of an illust and downloads its image,
+// or queues the pages and ugoira requests of works with more than one file
+func handleIllustDetail(task *service.Task, c *colly.Collector, r *colly.Response) {
+	var resp struct {
+		Body struct {
+			workDetail
+			IllustType int `json:"illustType"` // 0: illust, 1: manga, 2: ugoira
+			PageCount  int `json:"pageCount"`
+			Width      int `json:"width"`
+			Height     int `json:"height"`
+			Urls       struct {
+				Original string `json:"original"`
+			} `json:"urls"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(r.Body, &resp); err != nil {
+		return
+	}
+
+	// Everything the follow-up requests need to build the result travels in the request context
+	r.Ctx.Put("illustID", resp.Body.Id)
+	r.Ctx.Put("title", resp.Body.Title)
+	r.Ctx.Put("userID", resp.Body.UserId)
+	r.Ctx.Put("userName", resp.Body.UserName)
+	if r.Ctx.Get("category") == "" {
+		r.Ctx.Put("category", "illust")
+		if resp.Body.IllustType == 1 {
+			r.Ctx.Put("category", "manga")
+		}
+	}
+	if series := resp.Body.Series(); series != nil {
+		r.Ctx.Put("series", series)
+	}
+	r.Ctx.Put("updateDate", resp.Body.UploadDate)
+
+	work := resp.Body.Work("illust", illustTypeName(resp.Body.IllustType))
+	work.Width = resp.Body.Width
+	work.Height = resp.Body.Height
+	work.PageCount = resp.Body.PageCount
+	r.Ctx.Put("work", work)
+
+	ref := model.WorkRef{ID: resp.Body.Id, Kind: "illust"}
+	facts := resp.Body.Facts(work.Type)
+	resume := func() { handleIllustDetail(task, c, r) }
+	if !passesFilter(task, ref, facts, resume) {
+		return
+	}
+
+	// Shown without its file (removed, or hidden from this session although logged in): nothing to download,
+	// and nothing to fetch again on resume
+	if resp.Body.Urls.Original == "" && resp.Body.IllustType != 2 && resp.Body.PageCount <= 1 {
+		task.Logger.Warn("Skipping illust %s, its detail has no original image", resp.Body.Id)
+		task.CountWork("skipped")
+		task.MarkDone(ref.Key())
+		return
+	}
+
+	// Already archived and unchanged: keep the result, skip the downloads
+	if archiveStatus(task, resp.Body.Id, resp.Body.UploadDate) == "unchanged" {
+		archived, _ := openArchiveIndex(task.Dir).Lookup(resp.Body.Id)
+		imgURLs := make([]string, 0, len(archived.Files))
+		for _, file := range archived.Files {
+			imgURLs = append(imgURLs, file.URL)
+		}
+		finishIllust(task, r.Ctx, imgURLs)
+		return
+	}
+
+	// Ugoira only exposes the first frame here, the frames come from the ugoira meta endpoint
+	if resp.Body.IllustType == 2 {
+		task.Logger.Info("Illust %s is an ugoira", resp.Body.Id)
+		metaURL := fmt.Sprintf("https://www.pixiv.net/ajax/illust/%s/ugoira_meta", resp.Body.Id)
+		c.Request("GET", metaURL, nil, r.Ctx, nil)
+		return
+	}
+
+	// Multi-page works only expose page 0 here, the full list comes from the pages endpoint
+	if resp.Body.PageCount > 1 {
+		task.Logger.Info("Illust %s has %d pages", resp.Body.Id, resp.Body.PageCount)
+		pagesURL := fmt.Sprintf("https://www.pixiv.net/ajax/illust/%s/pages", resp.Body.Id)
+		c.Request("GET", pagesURL, nil, r.Ctx, nil)
+		return
+	}
+
+	imgURL := resp.Body.Urls.Original
+	task.Logger.Info("Found image: %s", imgURL)
+
+	if downloadsImages(task) {
+		img := downloadIllustPage(task, r.Ctx, 0, imgURL)
+		// A cancelled download leaves nothing behind, neither should its result
+		if task.Context().Err() != nil {
+			return
+		}
+		recordArchived(task, r.Ctx, []model.ImageInfo{img})
+	}
+
+	finishIllust(task, r.Ctx, []string{imgURL})
+}
+
+// handleIllustPages downloads every page of a multi-page work
+func handleIllustPages(task *service.Task, illustID string, r *colly.Response) {
+	var resp struct {
+		Body []struct {
+			Urls struct {
+				Original string `json:"original"`
+			} `json:"urls"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(r.Body, &resp); err != nil {
+		task.Logger.Error("Failed to parse pages of illust %s: %v", illustID, err)
+		return
+	}
+
+	imgURLs := make([]string, 0, len(resp.Body))
+	images := make([]model.ImageInfo, 0, len(resp.Body))
+	for page, p := range resp.Body {
+		if p.Urls.Original == "" {
+			continue
+		}
+		imgURLs = append(imgURLs, p.Urls.Original)
+		task.Logger.Info("Found image: %s (page %d)", p.Urls.Original, page)
+
+		if downloadsImages(task) {
+			images = append(images, downloadIllustPage(task, r.Ctx, page, p.Urls.Original))
+		}
+	}
+	if task.Context().Err() != nil {
+		return
+	}
+	recordArchived(task, r.Ctx, images)
+
+	finishIllust(task, r.Ctx, imgURLs)
+}
+
+// profileWorkIDs extracts the work IDs of a profile/all category.
+// Pixiv sends an object keyed by ID, or an empty array when the user has no works in it
+func profileWorkIDs(raw json.RawMessage) []string {
+	var works map[string]any
+	if err := json.Unmarshal(raw, &works); err != nil {
+		return nil
+	}
+	ids := make([]string, 0, len(works))
+	for id := range works {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// visitIllust queues the detail request of an illust, carrying what the listing knew about it along.
+// Works the listing already shows as archived and unchanged are skipped without requesting their detail
+func visitIllust(task *service.Task, c *colly.Collector, ref model.WorkRef) {
+	// Finished before a restart
+	if task.IsDone(ref.Key()) {
+		return
+	}
+	task.AddPending(ref)
+
+	if ref.UpdateDate != "" && downloadsImages(task) && !limitsWorks(task) && openArchiveIndex(task.Dir).Status(ref.ID, ref.UpdateDate) == "unchanged" {
+		task.Logger.Info("Skipping illust %s, already archived", ref.ID)
+		task.CountWork("skipped")
+		task.MarkDone(ref.Key())
+		return
+	}
+
+	ctx := colly.NewContext()
+	ctx.Put("category", ref.Category)
+	if ref.Rank > 0 {
+		ctx.Put("rank", ref.Rank)
+		ctx.Put("rankDate", ref.RankDate)
+	}
+	detailURL := fmt.Sprintf("https://www.pixiv.net/ajax/illust/%s", ref.ID)
+	c.Request("GET", detailURL, nil, ctx, nil)
+}
+
+// visitNovel queues the detail request of a novel
+func visitNovel(task *service.Task, c *colly.Collector, ref model.WorkRef) {
+	if task.IsDone(ref.Key()) {
+		return
+	}
+	task.AddPending(ref)
+
+	detailURL := fmt.Sprintf("https://www.pixiv.net/ajax/novel/%s", ref.ID)
+	c.Visit(detailURL)
+}
+
+// finishIllust adds the result of an illust and marks it done in the crawl frontier
+func finishIllust(task *service.Task, ctx *colly.Context, imageURLs []string) {
+	task.AddResult(illustResult(task, ctx, imageURLs))
+	task.MarkDone(model.WorkRef{ID: ctx.Get("illustID"), Kind: "illust"}.Key())
+}
+
+// illustResult builds the result of an illust from the values carried in its request context
+func illustResult(task *service.Task, ctx *colly.Context, imageURLs []string) model.TaskResult {
+	series, _ := ctx.GetAny("series").(*model.SeriesInfo)
+	rank, _ := ctx.GetAny("rank").(int)
+	work, _ := ctx.GetAny("work").(*model.Work)
+	result := model.TaskResult{
+		UserID:    ctx.Get("userID"),
+		UserName:  ctx.Get("userName"),
+		ImageURLs: imageURLs,
+		WorkID:    ctx.Get("illustID"),
+		Title:     ctx.Get("title"),
+		Category:  ctx.Get("category"),
+		Series:    series,
+		Rank:      rank,
+		RankDate:  ctx.Get("rankDate"),
+		Work:      work,
+	}
+	if result.UserID == "" {
+		result.UserID = task.UserInfo.UserID
+	}
+	if task.Mode == "bookmarks" {
+		result.BookmarkedBy = task.UserInfo.UserID
+	}
+	return result
+}
@@ -0,0 +1,79 @@
+package crawler
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go-crawler-client/internal/pkg/preview"
+)
+
+// previewSizes are the longest sides of the JPEG previews generated after each download
+var previewSizes = []int{256, 1024}
+
+// previewPath returns where a preview of an image under .download_imgs is cached,
+// .previews/<size>/<path under .download_imgs>.jpg
+func previewPath(dir string, imgPath string, size int) (string, error) {
+	rel, err := filepath.Rel(filepath.Join(dir, ".download_imgs"), imgPath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is not a downloaded image", imgPath)
+	}
+	return filepath.Join(dir, ".previews", fmt.Sprint(size), rel+".jpg"), nil
+}
+
+// makePreviews decodes and flattens a downloaded image once and writes the previews of the given sizes,
+// each scaled from the next larger one
+func makePreviews(dir string, imgPath string, sizes ...int) error {
+	f, err := os.Open(imgPath)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	sizes = slices.Clone(sizes)
+	slices.Sort(sizes)
+	scaled := preview.Flatten(img)
+	for _, size := range slices.Backward(sizes) {
+		outPath, err := previewPath(dir, imgPath, size)
+		if err != nil {
+			return err
+		}
+		scaled = preview.Scale(scaled, size)
+		if err := preview.WriteJPEG(scaled, outPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PreviewFile returns the preview of a downloaded image (see FindImageFile), generating it when it is missing,
+// as for images downloaded before previews existed
+func PreviewFile(dir string, imgPath string, size int) (string, error) {
+	if !slices.Contains(previewSizes, size) {
+		return "", fmt.Errorf("unsupported preview size %d, expected one of %v", size, previewSizes)
+	}
+	switch strings.ToLower(filepath.Ext(imgPath)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+	default:
+		return "", fmt.Errorf("no preview for %s", filepath.Base(imgPath))
+	}
+
+	outPath, err := previewPath(dir, imgPath, size)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(outPath); err == nil {
+		return outPath, nil
+	}
+	if err := makePreviews(dir, imgPath, size); err != nil {
+		return "", err
+	}
+	return outPath, nil
+}
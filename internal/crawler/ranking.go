@@ -0,0 +1,117 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// rankingPageSize is the number of entries ranking.php returns per page
+const rankingPageSize = 50
+
+var rankingModes = map[string]bool{
+	"daily": true, "weekly": true, "monthly": true, "rookie": true, "original": true,
+	"daily_ai": true, "male": true, "female": true,
+	"daily_r18": true, "weekly_r18": true, "daily_r18_ai": true, "male_r18": true, "female_r18": true, "r18g": true,
+}
+
+var rankingContents = map[string]bool{
+	"all": true, "illust": true, "ugoira": true, "manga": true,
+}
+
+// validateRankingOptions checks the options of a ranking task and fills in the defaults
+func validateRankingOptions(opts *model.RankingOptions) error {
+	if opts == nil {
+		return errors.New("missing required field: ranking")
+	}
+
+	opts.Mode = valueOr(opts.Mode, "daily")
+	opts.Content = valueOr(opts.Content, "all")
+	opts.Date = strings.ReplaceAll(opts.Date, "-", "")
+	if opts.Top <= 0 {
+		opts.Top = rankingPageSize
+	}
+
+	if !rankingModes[opts.Mode] {
+		return fmt.Errorf("invalid ranking mode: %s", opts.Mode)
+	}
+	if !rankingContents[opts.Content] {
+		return fmt.Errorf("invalid ranking content: %s", opts.Content)
+	}
+	if opts.Date != "" {
+		if _, err := time.Parse("20060102", opts.Date); err != nil {
+			return fmt.Errorf("invalid ranking date %q, expected YYYYMMDD", opts.Date)
+		}
+	}
+	return nil
+}
+
+// rankingURL builds the URL of one page (starting at 1) of a ranking
+func rankingURL(task *service.Task, page int) string {
+	opts := task.Options.Ranking
+
+	q := url.Values{}
+	q.Set("format", "json")
+	q.Set("mode", opts.Mode)
+	if opts.Content != "all" {
+		q.Set("content", opts.Content)
+	}
+	if opts.Date != "" {
+		q.Set("date", opts.Date)
+	}
+	q.Set("p", strconv.Itoa(page))
+	return "https://www.pixiv.net/ranking.php?" + q.Encode()
+}
+
+// handleRankingPage queues the ranked works of a page and then the next page, until the top N are queued
+func handleRankingPage(task *service.Task, c *colly.Collector, r *colly.Response) {
+	var resp struct {
+		Contents []struct {
+			IllustID json.Number `json:"illust_id"`
+			Rank     int         `json:"rank"`
+		} `json:"contents"`
+		Date      string          `json:"date"`
+		Next      json.RawMessage `json:"next"` // next page number, or false on the last page
+		RankTotal int             `json:"rank_total"`
+	}
+	if err := json.Unmarshal(r.Body, &resp); err != nil {
+		task.Logger.Error("Failed to parse ranking: %v", err)
+		return
+	}
+
+	top := task.Options.Ranking.Top
+	task.Logger.Info("Ranking %s: %d works on this page (%d total)", resp.Date, len(resp.Contents), resp.RankTotal)
+
+	for _, entry := range resp.Contents {
+		if entry.Rank > top {
+			task.Logger.Info("Reached top %d, stopping ranking", top)
+			task.SetListingDone()
+			return
+		}
+		if listingFull(task, entry.Rank-1) {
+			return
+		}
+		visitIllust(task, c, model.WorkRef{
+			ID:       entry.IllustID.String(),
+			Kind:     "illust",
+			Rank:     entry.Rank,
+			RankDate: resp.Date,
+		})
+	}
+
+	var next int
+	if err := json.Unmarshal(resp.Next, &next); err != nil || next == 0 || (next-1)*rankingPageSize >= top {
+		task.SetListingDone()
+		return
+	}
+	r.Request.Visit(rankingURL(task, next))
+}
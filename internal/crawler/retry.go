@@ -0,0 +1,93 @@
+package crawler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go-crawler-client/config"
+	"go-crawler-client/internal/pkg/retry"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// statusError is a download answered with something else than 200
+type statusError struct {
+	Code       int
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("status code: %d", e.Code)
+}
+
+// retryPolicy reads the retry settings of the config, a single attempt when retries are disabled
+func retryPolicy() retry.Policy {
+	return retry.Policy{
+		Attempts:  max(config.GlobalConfig.RetryAttempts, 1),
+		BaseDelay: time.Duration(config.GlobalConfig.RetryBaseDelayMs) * time.Millisecond,
+		MaxDelay:  time.Duration(config.GlobalConfig.RetryMaxDelayMs) * time.Millisecond,
+	}
+}
+
+// downloadWithRetry downloads a file, trying again after transient failures and corrupt bodies.
+// It returns the checksum and how many attempts were made
+func downloadWithRetry(task *service.Task, dl DownloadRequest, filepath string) (string, int, error) {
+	policy := retryPolicy()
+	for attempt := 1; ; attempt++ {
+		checksum, err := downloadFile(task.Context(), dl, filepath)
+		if err == nil || task.Context().Err() != nil {
+			return checksum, attempt, err
+		}
+
+		code, retryAfter := 0, time.Duration(0)
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			code, retryAfter = statusErr.Code, statusErr.RetryAfter
+		}
+		if attempt >= policy.Attempts || !(retry.Retryable(code, err) || errors.Is(err, errCorrupt)) {
+			return "", attempt, err
+		}
+
+		delay := policy.Delay(attempt, retryAfter)
+		task.Logger.Warn("Download %s failed (attempt %d/%d): %v, retrying in %s", dl.URL, attempt, policy.Attempts, err, delay.Round(time.Millisecond))
+		if retry.Sleep(task.Context(), delay) != nil || task.WaitIfPaused() != nil {
+			return "", attempt, err
+		}
+	}
+}
+
+// retryRequest schedules a failed colly request again when the failure is transient.
+// The attempt count travels in the request context, keyed by URL since follow-up requests share it
+func retryRequest(task *service.Task, r *colly.Response, err error) bool {
+	policy := retryPolicy()
+	key := "attempts:" + r.Request.URL.String()
+	done, _ := r.Ctx.GetAny(key).(int)
+	done++
+
+	if done >= policy.Attempts || !retry.Retryable(r.StatusCode, err) {
+		if done > 1 {
+			task.Logger.Error("Request %s failed after %d attempts", r.Request.URL, done)
+		}
+		return false
+	}
+	r.Ctx.Put(key, done)
+
+	var retryAfter time.Duration
+	if r.Headers != nil {
+		retryAfter = retry.ParseRetryAfter(r.Headers.Get("Retry-After"))
+	}
+	delay := policy.Delay(done, retryAfter)
+	task.Logger.Warn("Request %s failed (attempt %d/%d): %v, retrying in %s", r.Request.URL, done, policy.Attempts, err, delay.Round(time.Millisecond))
+
+	// Cancelled while waiting, there is nothing left to report
+	if retry.Sleep(task.Context(), delay) != nil {
+		return true
+	}
+	if retryErr := r.Request.Retry(); retryErr != nil {
+		task.Logger.Error("Failed to retry %s: %v", r.Request.URL, retryErr)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package crawler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+var searchPattern = regexp.MustCompile(`^/ajax/search/artworks/`)
+
+// searchModes maps our search modes to Pixiv's s_mode parameter
+var searchModes = map[string]string{
+	"":         "s_tag",
+	"tag":      "s_tag",
+	"tag_full": "s_tag_full",
+	"title":    "s_tc",
+}
+
+// searchURL builds the URL of one result page (starting at 1) of a search task
+func searchURL(task *service.Task, page int) string {
+	opts := task.Options.Search
+
+	q := url.Values{}
+	q.Set("word", opts.Word)
+	q.Set("p", strconv.Itoa(page))
+	q.Set("s_mode", searchModes[opts.Mode])
+	q.Set("order", valueOr(opts.Order, "date_d"))
+	q.Set("type", valueOr(opts.Type, "all"))
+	q.Set("mode", valueOr(opts.Rating, "all"))
+	if opts.DateFrom != "" {
+		q.Set("scd", opts.DateFrom)
+	}
+	if opts.DateTo != "" {
+		q.Set("ecd", opts.DateTo)
+	}
+	return fmt.Sprintf("https://www.pixiv.net/ajax/search/artworks/%s?%s", url.PathEscape(opts.Word), q.Encode())
+}
+
+// validateSearchOptions checks the options of a search task before it is created
+func validateSearchOptions(opts *model.SearchOptions) error {
+	if opts == nil || strings.TrimSpace(opts.Word) == "" {
+		return errors.New("missing required field: search.word")
+	}
+	if _, ok := searchModes[opts.Mode]; !ok {
+		return fmt.Errorf("invalid search mode: %s", opts.Mode)
+	}
+	for _, date := range []string{opts.DateFrom, opts.DateTo} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return fmt.Errorf("invalid search date %q, expected YYYY-MM-DD", date)
+		}
+	}
+	return nil
+}
+
+// handleSearchPage queues the works of a search result page and then the next page,
+// until Pixiv runs out of results or the page/work caps are reached
+func handleSearchPage(task *service.Task, c *colly.Collector, r *colly.Response) {
+	var resp struct {
+		Body struct {
+			IllustManga struct {
+				Data []struct {
+					Id         json.Number `json:"id"`
+					UpdateDate string      `json:"updateDate"`
+				} `json:"data"`
+				Total    int `json:"total"`
+				LastPage int `json:"lastPage"`
+			} `json:"illustManga"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(r.Body, &resp); err != nil {
+		task.Logger.Error("Failed to parse search results: %v", err)
+		return
+	}
+
+	opts := task.Options.Search
+	result := resp.Body.IllustManga
+	page, _ := strconv.Atoi(r.Request.URL.Query().Get("p"))
+	queued, _ := r.Ctx.GetAny("queued").(int)
+	task.Logger.Info("Search page %d/%d: %d works (%d total)", page, result.LastPage, len(result.Data), result.Total)
+
+	for _, work := range result.Data {
+		// Ad containers come without an ID
+		if work.Id == "" {
+			continue
+		}
+		if opts.MaxWorks > 0 && queued >= opts.MaxWorks {
+			task.Logger.Info("Reached max works (%d), stopping search", opts.MaxWorks)
+			task.SetListingDone()
+			return
+		}
+		if listingFull(task, queued) {
+			return
+		}
+		visitIllust(task, c, model.WorkRef{ID: work.Id.String(), Kind: "illust", UpdateDate: work.UpdateDate})
+		queued++
+	}
+
+	if len(result.Data) == 0 || page >= result.LastPage {
+		task.SetListingDone()
+		return
+	}
+	if opts.MaxPages > 0 && page >= opts.MaxPages {
+		task.Logger.Info("Reached max pages (%d), stopping search", opts.MaxPages)
+		task.SetListingDone()
+		return
+	}
+
+	ctx := colly.NewContext()
+	ctx.Put("queued", queued)
+	c.Request("GET", searchURL(task, page+1), nil, ctx, nil)
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
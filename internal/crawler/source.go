@@ -0,0 +1,89 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Source is a site the crawler can archive. The crawler owns the collector, the task lifecycle,
+// pausing, retries and the archive, a source only knows the URLs, headers and JSON shapes of its site
+type Source interface {
+	// Name identifies the source, e.g. "pixiv"
+	Name() string
+	// Modes lists the task modes the source handles, a mode belongs to a single source
+	Modes() []string
+	// RequiresUser reports whether a mode starts from a user of the site
+	RequiresUser(mode string) bool
+	// Validate checks the mode specific options of a task before it is created
+	Validate(mode string, userID string, options model.TaskOptions) error
+	// ResolveUser resolves the user a task targets, fetching its name and avatar.
+	// Requests made with ctx take their cookie from the account pool it carries
+	ResolveUser(ctx context.Context, userID string) (model.UserInfo, error)
+	// CheckAuth reports who a cookie is logged in as, and returns an *AuthError when it cannot run the task
+	CheckAuth(mode string, cookie string, options model.TaskOptions) (model.AuthStatus, error)
+
+	// Attach registers the response handlers that enumerate works, parse their metadata and download their files.
+	// They are registered with onResponse, which skips the responses of a lost session
+	Attach(task *service.Task, c *colly.Collector)
+	// List queues the first listing request of a task
+	List(task *service.Task, c *colly.Collector)
+	// Visit queues the metadata request of a work, used for works left over from before a restart
+	Visit(task *service.Task, c *colly.Collector, ref model.WorkRef)
+
+	// SessionLost reports whether a response looks like the session was logged out mid-crawl,
+	// the crawler then confirms it with CheckAuth
+	SessionLost(r *colly.Response) bool
+
+	// PrepareRequest sets the headers every request of the collector needs, such as the cookie
+	PrepareRequest(r *colly.Request, cookie string)
+	// Download returns the request of a file, with the headers the site requires
+	Download(url string) DownloadRequest
+}
+
+// DownloadRequest is a file to download, along with the headers its host requires
+type DownloadRequest struct {
+	URL    string
+	Header http.Header
+}
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = make(map[string]Source) // mode -> source
+)
+
+// RegisterSource makes the modes of a source available to tasks, it panics when a mode is already taken
+func RegisterSource(src Source) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	for _, mode := range src.Modes() {
+		if other, ok := sources[mode]; ok {
+			panic(fmt.Sprintf("crawler: mode %s of source %s is already registered by %s", mode, src.Name(), other.Name()))
+		}
+		sources[mode] = src
+	}
+}
+
+// SourceFor returns the source that handles a task mode
+func SourceFor(mode string) (Source, bool) {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	src, ok := sources[mode]
+	return src, ok
+}
+
+// sourceOf returns the source of a running task. Tasks are only created for registered modes,
+// it panics on any other (StartCrawler recovers and fails the task)
+func sourceOf(task *service.Task) Source {
+	src, ok := SourceFor(task.Mode)
+	if !ok {
+		panic(fmt.Sprintf("crawler: no source registered for mode %s of task %s", task.Mode, task.ID))
+	}
+	return src
+}
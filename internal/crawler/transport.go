@@ -0,0 +1,135 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"go-crawler-client/config"
+	"go-crawler-client/internal/account"
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/pkg/proxy"
+	"go-crawler-client/internal/pkg/throttle"
+)
+
+const (
+	pixivHost = "www.pixiv.net"
+	pximgHost = "i.pximg.net"
+)
+
+var (
+	schedulerOnce sync.Once
+	scheduler     *throttle.Scheduler
+
+	transportOnce sync.Once
+	transport     http.RoundTripper
+
+	proxyOnce sync.Once
+	proxies   *proxy.Selector
+	proxyErr  error
+)
+
+// requestScheduler returns the process-wide scheduler, created from the config on first use
+func requestScheduler() *throttle.Scheduler {
+	schedulerOnce.Do(func() {
+		cfg := config.GlobalConfig
+		scheduler = throttle.New(cfg.MaxConcurrentRequests, hostLimits(model.RateLimits{
+			PixivRate:  cfg.PixivRate,
+			PixivBurst: cfg.PixivBurst,
+			PximgRate:  cfg.PximgRate,
+			PximgBurst: cfg.PximgBurst,
+		}))
+	})
+	return scheduler
+}
+
+// InitProxy checks the proxy settings of the config, no connection should leave the client before it succeeds
+func InitProxy() error {
+	proxySelector()
+	return proxyErr
+}
+
+// proxySelector returns the process-wide proxy selector, created from the config on first use.
+// Invalid settings fall back to the proxy of the environment
+func proxySelector() *proxy.Selector {
+	proxyOnce.Do(func() {
+		cfg := config.GlobalConfig
+		proxies, proxyErr = proxy.New(proxy.Settings{
+			Mode:     cfg.ProxyMode,
+			Host:     cfg.ProxyHost,
+			Port:     cfg.ProxyPort,
+			User:     cfg.ProxyUser,
+			Password: cfg.ProxyPassword,
+			Bypass:   cfg.ProxyBypass,
+			Pool:     cfg.ProxyPool,
+		})
+		if proxyErr != nil {
+			log.Printf("Warning: invalid proxy settings: %v. Using the proxy of the environment.", proxyErr)
+			proxies, _ = proxy.New(proxy.Settings{Mode: proxy.ModeEnv})
+		}
+	})
+	return proxies
+}
+
+// httpTransport returns the transport every Pixiv request goes through, colly, API calls and downloads alike.
+// It applies the configured proxy and the process-wide request limits, and counts the requests of each account
+func httpTransport() http.RoundTripper {
+	transportOnce.Do(func() {
+		sel := proxySelector()
+		base := &http.Transport{Proxy: sel.Proxy}
+		transport = account.Transport(requestScheduler().Transport(sel.Transport(base)))
+	})
+	return transport
+}
+
+// DialContext opens a connection through the configured proxy, for the WebSocket to the backend
+func DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return proxySelector().DialContext(ctx, network, addr)
+}
+
+// GetProxyStatus returns the health of the configured proxies
+func GetProxyStatus() []model.ProxyStatus {
+	return proxySelector().Status()
+}
+
+// httpClient returns a client using the shared transport
+func httpClient() *http.Client {
+	return &http.Client{Transport: httpTransport()}
+}
+
+func hostLimits(limits model.RateLimits) map[string]throttle.Limit {
+	return map[string]throttle.Limit{
+		pixivHost: {Rate: limits.PixivRate, Burst: limits.PixivBurst},
+		pximgHost: {Rate: limits.PximgRate, Burst: limits.PximgBurst},
+	}
+}
+
+// GetRateLimits returns the process-wide request limits
+func GetRateLimits() model.RateLimits {
+	s := requestScheduler()
+	maxConcurrent, limits := s.Limits()
+	return model.RateLimits{
+		MaxConcurrent: maxConcurrent,
+		PixivRate:     limits[pixivHost].Rate,
+		PixivBurst:    limits[pixivHost].Burst,
+		PximgRate:     limits[pximgHost].Rate,
+		PximgBurst:    limits[pximgHost].Burst,
+		InFlight:      s.InFlight(),
+	}
+}
+
+// SetRateLimits changes the process-wide request limits of running and future tasks and saves them to the config
+func SetRateLimits(limits model.RateLimits) error {
+	if limits.MaxConcurrent < 0 || limits.PixivRate < 0 || limits.PximgRate < 0 || limits.PixivBurst < 0 || limits.PximgBurst < 0 {
+		return errors.New("limits must not be negative")
+	}
+	requestScheduler().SetLimits(limits.MaxConcurrent, hostLimits(limits))
+	if err := config.UpdateRateLimits(limits.MaxConcurrent, limits.PixivRate, limits.PixivBurst, limits.PximgRate, limits.PximgBurst); err != nil {
+		return fmt.Errorf("limits applied but not saved: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package crawler
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	_ "image/jpeg" // register decoders for ugoira frames
+	_ "image/png"
+	"os"
+	"regexp"
+
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/service"
+
+	"github.com/gocolly/colly/v2"
+)
+
+var ugoiraMetaPattern = regexp.MustCompile(`^/ajax/illust/(\d+)/ugoira_meta$`)
+
+type ugoiraFrame struct {
+	File  string `json:"file"`
+	Delay int    `json:"delay"` // milliseconds
+}
+
+type ugoiraMeta struct {
+	Src         string        `json:"src"`
+	OriginalSrc string        `json:"originalSrc"`
+	MimeType    string        `json:"mime_type"`
+	Frames      []ugoiraFrame `json:"frames"`
+}
+
+// handleUgoiraMeta downloads the frame zip of an ugoira, stores its frame delays
+// and assembles an animated GIF next to them
+func handleUgoiraMeta(task *service.Task, illustID string, ctx *colly.Context, body []byte) {
+	var resp struct {
+		Body ugoiraMeta `json:"body"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		task.Logger.Error("Failed to parse ugoira meta of illust %s: %v", illustID, err)
+		return
+	}
+
+	meta := resp.Body
+	zipURL := meta.OriginalSrc
+	if zipURL == "" {
+		zipURL = meta.Src
+	}
+	if zipURL == "" || len(meta.Frames) == 0 {
+		task.Logger.Error("Ugoira %s has no frames", illustID)
+		return
+	}
+	task.Logger.Info("Found ugoira: %s (%d frames)", zipURL, len(meta.Frames))
+
+	if downloadsImages(task) {
+		zipPath := imagePath(task, ctx, 0, "ugoira_zip", "zip")
+		framesPath := imagePath(task, ctx, 0, "ugoira_frames", "json")
+		gifPath := imagePath(task, ctx, 0, "ugoira", "gif")
+
+		if err := task.WaitIfPaused(); err != nil {
+			return
+		}
+
+		// 1. Raw frame zip (unless downloaded before a restart)
+		var zipChecksum string
+		var zipAttempts int
+		var err error
+		if img, ok := task.FindImage(zipPath); ok {
+			zipChecksum, zipAttempts = img.Checksum, img.Attempts
+		} else {
+			zipChecksum, zipAttempts, err = downloadWithRetry(task, sourceOf(task).Download(zipURL), zipPath)
+		}
+		if task.Context().Err() != nil {
+			return
+		}
+		zipStatus := downloadStatus(err)
+		if err != nil {
+			task.Logger.Error("Failed to download ugoira zip %s: %v", zipURL, err)
+		} else {
+			task.Logger.Info("Downloaded ugoira zip to %s", zipPath)
+		}
+		zipImage := model.ImageInfo{
+			URL:      zipURL,
+			Path:     zipPath,
+			IllustID: illustID,
+			Kind:     "ugoira_zip",
+			Checksum: zipChecksum,
+			Status:   zipStatus,
+			Attempts: zipAttempts,
+		}
+		task.AddImage(zipImage)
+
+		// 2. Frame list with delays
+		framesStatus := "success"
+		var framesChecksum string
+		if err := writeJSONFile(framesPath, meta); err != nil {
+			framesStatus = "failed"
+			task.Logger.Error("Failed to write ugoira frames %s: %v", framesPath, err)
+		} else {
+			_, framesChecksum, _ = hashFile(framesPath)
+		}
+		framesImage := model.ImageInfo{
+			URL:      zipURL,
+			Path:     framesPath,
+			IllustID: illustID,
+			Kind:     "ugoira_frames",
+			Checksum: framesChecksum,
+			Status:   framesStatus,
+		}
+		task.AddImage(framesImage)
+
+		// 3. Playable animation, only possible when the zip is on disk
+		gifStatus := "failed"
+		var gifChecksum string
+		if zipStatus == "success" {
+			if err := assembleUgoiraGIF(zipPath, meta.Frames, gifPath); err != nil {
+				task.Logger.Error("Failed to assemble ugoira %s: %v", illustID, err)
+			} else {
+				gifStatus = "success"
+				_, gifChecksum, _ = hashFile(gifPath)
+				task.Logger.Info("Assembled ugoira to %s", gifPath)
+				if err := makePreviews(task.Dir, gifPath, previewSizes...); err != nil {
+					task.Logger.Error("Failed to generate previews of %s: %v", gifPath, err)
+				}
+			}
+		}
+		gifImage := model.ImageInfo{
+			URL:      zipURL,
+			Path:     gifPath,
+			IllustID: illustID,
+			Kind:     "ugoira",
+			Checksum: gifChecksum,
+			Status:   gifStatus,
+		}
+		task.AddImage(gifImage)
+		releaseFailed(task, zipImage, framesImage, gifImage)
+
+		recordArchived(task, ctx, []model.ImageInfo{zipImage, framesImage, gifImage})
+	}
+
+	finishIllust(task, ctx, []string{zipURL})
+}
+
+// assembleUgoiraGIF turns the frames of an ugoira zip into an animated GIF
+func assembleUgoiraGIF(zipPath string, frames []ugoiraFrame, outPath string) error {
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	anim := &gif.GIF{}
+	for _, frame := range frames {
+		f, ok := files[frame.File]
+		if !ok {
+			return fmt.Errorf("frame %s missing from zip", frame.File)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		img, _, err := image.Decode(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("decode frame %s: %w", frame.File, err)
+		}
+
+		// GIF frames are paletted, dither the original colors into the Plan9 palette
+		bounds := img.Bounds()
+		paletted := image.NewPaletted(bounds, palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, bounds, img, bounds.Min)
+
+		// GIF delays are in 1/100s, and most viewers ignore anything below 2
+		delay := (frame.Delay + 5) / 10
+		if delay < 2 {
+			delay = 2
+		}
+
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return gif.EncodeAll(out, anim)
+}
+
+func writeJSONFile(path string, v any) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,144 @@
+package crawler
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go-crawler-client/config"
+	"go-crawler-client/internal/model"
+)
+
+// errCorrupt marks a download whose content does not match what the server announced
+var errCorrupt = errors.New("corrupt download")
+
+// downloadStatus maps a download error to the status of its ImageInfo
+func downloadStatus(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, errCorrupt):
+		return "corrupt"
+	}
+	return "failed"
+}
+
+// checkDecodable makes sure a downloaded JPEG, PNG or GIF decodes and a zip has a readable directory,
+// ext being the extension of the final file name. The image decoder is picked from the content, not the
+// extension: avatars are saved as .jpg whatever their format. Other files are not checked
+func checkDecodable(path string, ext string) error {
+	ext = strings.ToLower(ext)
+	if ext == ".zip" {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errCorrupt, err)
+		}
+		return zr.Close()
+	}
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".gif":
+	default:
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	switch contentType := http.DetectContentType(head[:n]); contentType {
+	case "image/jpeg":
+		_, err = jpeg.Decode(f)
+	case "image/png":
+		_, err = png.Decode(f)
+	case "image/gif":
+		_, err = gif.DecodeAll(f)
+	default:
+		// An error page or a truncated header instead of an image
+		err = fmt.Errorf("not an image (%s)", contentType)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+	return nil
+}
+
+var pixivUserIDPattern = regexp.MustCompile(`^\d+$`)
+
+// UserArchiveDir returns the task directory of a Pixiv user, crawl-datas/<uid>
+func UserArchiveDir(userID string) (string, error) {
+	if !pixivUserIDPattern.MatchString(userID) {
+		return "", fmt.Errorf("invalid pixiv_user_id: %q", userID)
+	}
+	return filepath.Join(config.GetBaseDir(), "crawl-datas", userID), nil
+}
+
+// VerifyArchive re-hashes the files of a task directory (crawl-datas/<uid> for a user) against its archive index.
+// Works with altered files are invalidated so the next task downloads them again,
+// works with missing files already are
+func VerifyArchive(dir string) (model.VerifyReport, error) {
+	report := model.VerifyReport{
+		Dir:     dir,
+		Missing: []model.VerifyFile{},
+		Altered: []model.VerifyFile{},
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".index", "archive.jsonl")); err != nil {
+		return report, fmt.Errorf("no archive index in %s", dir)
+	}
+
+	index := openArchiveIndex(dir)
+	for _, work := range index.Works() {
+		report.Works++
+		altered := false
+		for _, file := range work.Files {
+			report.Files++
+			problem := model.VerifyFile{
+				IllustID: work.ID,
+				Path:     file.Path,
+				Size:     file.Size,
+				SHA256:   file.SHA256,
+			}
+
+			size, sum, err := hashFile(filepath.Join(dir, filepath.FromSlash(file.Path)))
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				report.Missing = append(report.Missing, problem)
+			case err != nil:
+				return report, err
+			case size != file.Size || sum != file.SHA256:
+				problem.Actual = sum
+				report.Altered = append(report.Altered, problem)
+				altered = true
+			default:
+				report.OK++
+			}
+		}
+
+		if altered {
+			if err := index.Invalidate(work.ID); err != nil {
+				return report, err
+			}
+			report.Invalidated++
+		}
+	}
+	return report, nil
+}
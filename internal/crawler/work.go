@@ -0,0 +1,108 @@
+package crawler
+
+import (
+	"encoding/json"
+
+	"go-crawler-client/internal/model"
+)
+
+// workDetail holds the fields illust and novel details share
+type workDetail struct {
+	Id            string    `json:"id"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	UserId        string    `json:"userId"`
+	UserName      string    `json:"userName"`
+	CreateDate    string    `json:"createDate"`
+	UploadDate    string    `json:"uploadDate"` // changes when the artist updates the work
+	XRestrict     int       `json:"xRestrict"`
+	AIType        int       `json:"aiType"`
+	IsOriginal    bool      `json:"isOriginal"`
+	BookmarkCount int       `json:"bookmarkCount"`
+	LikeCount     int       `json:"likeCount"`
+	ViewCount     int       `json:"viewCount"`
+	CommentCount  int       `json:"commentCount"`
+	Tags          pixivTags `json:"tags"`
+	SeriesNavData *struct {
+		SeriesId json.Number `json:"seriesId"`
+		Title    string      `json:"title"`
+		Order    int         `json:"order"`
+	} `json:"seriesNavData"`
+}
+
+// pixivTags is the tags object of illust and novel details
+type pixivTags struct {
+	Tags []struct {
+		Tag         string            `json:"tag"`
+		Romaji      string            `json:"romaji"`
+		Translation map[string]string `json:"translation"`
+		Locked      bool              `json:"locked"`
+	} `json:"tags"`
+}
+
+// Names returns the tag names
+func (t pixivTags) Names() []string {
+	names := make([]string, 0, len(t.Tags))
+	for _, tag := range t.Tags {
+		names = append(names, tag.Tag)
+	}
+	return names
+}
+
+// Series returns the series the work belongs to, or nil
+func (d workDetail) Series() *model.SeriesInfo {
+	if d.SeriesNavData == nil {
+		return nil
+	}
+	return &model.SeriesInfo{
+		ID:    d.SeriesNavData.SeriesId.String(),
+		Title: d.SeriesNavData.Title,
+		Order: d.SeriesNavData.Order,
+	}
+}
+
+// Facts returns what the task filter looks at
+func (d workDetail) Facts(workType string) workFacts {
+	return workFacts{
+		CreateDate: d.CreateDate,
+		Tags:       d.Tags.Names(),
+		XRestrict:  d.XRestrict,
+		AIType:     d.AIType,
+		Bookmarks:  d.BookmarkCount,
+		Likes:      d.LikeCount,
+		Type:       workType,
+	}
+}
+
+// Work builds the metadata record of the work, kind-specific fields are filled in by the caller
+func (d workDetail) Work(kind string, workType string) *model.Work {
+	work := &model.Work{
+		ID:            d.Id,
+		Kind:          kind,
+		Type:          workType,
+		Title:         d.Title,
+		Caption:       d.Description,
+		UserID:        d.UserId,
+		UserName:      d.UserName,
+		Tags:          make([]model.WorkTag, 0, len(d.Tags.Tags)),
+		CreateDate:    d.CreateDate,
+		UploadDate:    d.UploadDate,
+		BookmarkCount: d.BookmarkCount,
+		LikeCount:     d.LikeCount,
+		ViewCount:     d.ViewCount,
+		CommentCount:  d.CommentCount,
+		XRestrict:     d.XRestrict,
+		AIType:        d.AIType,
+		IsOriginal:    d.IsOriginal,
+		Series:        d.Series(),
+	}
+	for _, tag := range d.Tags.Tags {
+		work.Tags = append(work.Tags, model.WorkTag{
+			Tag:          tag.Tag,
+			Romaji:       tag.Romaji,
+			Translations: tag.Translation,
+			Locked:       tag.Locked,
+		})
+	}
+	return work
+}
@@ -2,9 +2,51 @@ package model
 
 // StartTaskRequest 启动任务请求
 type StartTaskRequest struct {
-	PixivUserID string `json:"pixiv_user_id" binding:"required"`
-	Cookie      string `json:"cookie" binding:"required"`
-	Token       string `json:"token" binding:"required"` // Added Token field
+	PixivUserID string `json:"pixiv_user_id"`                                          // search 模式下不需要
+	Cookie      string `json:"cookie" binding:"required_without_all=Account Accounts"` // 使用账号库中的账号 (account / accounts) 时不需要
+	Token       string `json:"token" binding:"required"`                               // Added Token field
+	TaskOptions
+}
+
+// TaskOptions 各模式的可选参数
+type TaskOptions struct {
+	Bookmarks *BookmarkOptions `json:"bookmarks,omitempty"`
+	Search    *SearchOptions   `json:"search,omitempty"`
+	Ranking   *RankingOptions  `json:"ranking,omitempty"`
+	Filter    *FilterSpec      `json:"filter,omitempty"`
+	// 文件名模板, 如 {user_name}/{illust_id}_{title}_p{page}.{ext}, 为空时使用配置中的模板
+	FilenameTemplate string `json:"filename_template,omitempty"`
+	// 本地账号库中的账号别名, 抓取时使用该账号的 Cookie, 代替请求中的 cookie
+	Account string `json:"account,omitempty"`
+	// 账号池 (账号别名), 请求在这些账号之间轮换, 与 account 合并
+	Accounts []string `json:"accounts,omitempty"`
+}
+
+// AccountAliases 任务使用的全部账号别名 (account 与 accounts 合并)
+func (o TaskOptions) AccountAliases() []string {
+	if o.Account == "" {
+		return o.Accounts
+	}
+	return append([]string{o.Account}, o.Accounts...)
+}
+
+// BookmarkOptions 收藏模式参数
+type BookmarkOptions struct {
+	Visibility string `json:"visibility"` // public (默认), private
+	Tag        string `json:"tag"`        // 只抓取带有该收藏标签的作品
+}
+
+// SearchOptions 搜索模式参数
+type SearchOptions struct {
+	Word     string `json:"word"`      // 关键词或标签
+	Mode     string `json:"mode"`      // tag (标签部分一致, 默认), tag_full (标签完全一致), title (标题和简介)
+	Order    string `json:"order"`     // date_d (最新, 默认), date (最旧), popular_d (热门, 需要会员)
+	Type     string `json:"type"`      // all (默认), illust, manga, ugoira
+	Rating   string `json:"rating"`    // all (默认), safe, r18
+	DateFrom string `json:"date_from"` // YYYY-MM-DD
+	DateTo   string `json:"date_to"`   // YYYY-MM-DD
+	MaxPages int    `json:"max_pages"` // 0 表示不限制
+	MaxWorks int    `json:"max_works"` // 0 表示不限制
 }
 
 // UserInfo 用户信息
@@ -27,25 +69,177 @@ type StartTaskResponse struct {
 type ImageInfo struct {
 	URL      string `json:"url"`
 	Path     string `json:"path"`
-	Checksum string `json:"checksum"`
-	Status   string `json:"status"`
+	IllustID string `json:"illust_id,omitempty"`
+	Page     int    `json:"page"`     // 多图作品中的页码 (从 0 开始)
+	Kind     string `json:"kind"`     // illust, ugoira, ugoira_zip, ugoira_frames
+	Checksum string `json:"checksum"` // SHA-256 (hex)
+	Status   string `json:"status"`   // success, failed, corrupt, cancelled
+	Attempts int    `json:"attempts"` // 下载尝试次数 (含重试)
+}
+
+// VerifyReport 归档文件校验结果
+type VerifyReport struct {
+	Dir         string       `json:"dir"`
+	Works       int          `json:"works"` // 校验的作品数
+	Files       int          `json:"files"` // 校验的文件数
+	OK          int          `json:"ok"`
+	Missing     []VerifyFile `json:"missing"`
+	Altered     []VerifyFile `json:"altered"`     // 大小或 SHA-256 与归档记录不符
+	Invalidated int          `json:"invalidated"` // 下次任务会重新下载的作品数
+}
+
+// VerifyFile 校验未通过的文件
+type VerifyFile struct {
+	IllustID string `json:"illust_id"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`                  // 归档记录中的值
+	Actual   string `json:"actual_sha256,omitempty"` // 磁盘上文件的值
+}
+
+// SeriesInfo 系列信息
+type SeriesInfo struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Order int    `json:"order"` // 在系列中的序号 (从 1 开始)
+}
+
+// RankingOptions 排行榜模式参数
+type RankingOptions struct {
+	Mode    string `json:"mode"`    // daily (默认), weekly, monthly, rookie, original, daily_ai, male, female 以及 *_r18 变体
+	Content string `json:"content"` // all (默认), illust, ugoira, manga
+	Date    string `json:"date"`    // YYYYMMDD 或 YYYY-MM-DD, 为空时为最新排行榜
+	Top     int    `json:"top"`     // 前 N 名, 默认 50
+}
+
+// FilterSpec 作品过滤条件, 在获取详情之后, 下载之前生效
+type FilterSpec struct {
+	DateFrom     string   `json:"date_from"`     // 投稿日期 YYYY-MM-DD (含)
+	DateTo       string   `json:"date_to"`       // 投稿日期 YYYY-MM-DD (含)
+	IncludeTags  []string `json:"include_tags"`  // 至少带有其中一个标签
+	ExcludeTags  []string `json:"exclude_tags"`  // 带有其中任一标签则跳过
+	XRestrict    []string `json:"x_restrict"`    // all-ages, r18, r18g, 为空表示不限制
+	AI           string   `json:"ai"`            // 为空不限制, exclude: 排除 AI 生成, only: 只要 AI 生成
+	MinBookmarks int      `json:"min_bookmarks"` // 最少收藏数
+	MinLikes     int      `json:"min_likes"`     // 最少点赞数
+	IllustTypes  []string `json:"illust_types"`  // illust, manga, ugoira, 为空表示不限制
+	MaxWorks     int      `json:"max_works"`     // 最多 N 个作品 (从最新开始), 0 表示不限制
+}
+
+// Work 作品完整元数据
+type Work struct {
+	ID            string      `json:"id"`
+	Kind          string      `json:"kind"` // illust, novel
+	Type          string      `json:"type"` // illust, manga, ugoira, novel
+	Title         string      `json:"title"`
+	Caption       string      `json:"caption"` // 作品说明 (HTML)
+	UserID        string      `json:"user_id"`
+	UserName      string      `json:"user_name"`
+	Tags          []WorkTag   `json:"tags"`
+	CreateDate    string      `json:"create_date"`
+	UploadDate    string      `json:"upload_date"`
+	Width         int         `json:"width,omitempty"`
+	Height        int         `json:"height,omitempty"`
+	PageCount     int         `json:"page_count,omitempty"`
+	TextCount     int         `json:"text_count,omitempty"` // 小说字数
+	WordCount     int         `json:"word_count,omitempty"` // 小说词数
+	BookmarkCount int         `json:"bookmark_count"`
+	LikeCount     int         `json:"like_count"`
+	ViewCount     int         `json:"view_count"`
+	CommentCount  int         `json:"comment_count"`
+	XRestrict     int         `json:"x_restrict"` // 0: 全年龄, 1: R-18, 2: R-18G
+	AIType        int         `json:"ai_type"`    // 0: 未知, 1: 非 AI 生成, 2: AI 生成
+	IsOriginal    bool        `json:"is_original"`
+	Language      string      `json:"language,omitempty"`
+	Series        *SeriesInfo `json:"series,omitempty"`
+}
+
+// WorkTag 作品标签
+type WorkTag struct {
+	Tag          string            `json:"tag"`
+	Romaji       string            `json:"romaji,omitempty"`
+	Translations map[string]string `json:"translations,omitempty"` // 语言 -> 译名
+	Locked       bool              `json:"locked"`                 // 作者锁定的标签
 }
 
 // TaskResult 爬取结果
 type TaskResult struct {
-	UserID    string   `json:"user_id"`
-	UserName  string   `json:"user_name"`
-	ImageURLs []string `json:"image_urls"`
+	UserID    string      `json:"user_id"`
+	UserName  string      `json:"user_name"`
+	ImageURLs []string    `json:"image_urls"`
+	WorkID    string      `json:"work_id,omitempty"`
+	Title     string      `json:"title,omitempty"`
+	Category  string      `json:"category,omitempty"` // illust, manga, novel
+	Series    *SeriesInfo `json:"series,omitempty"`
+	Files     []string    `json:"files,omitempty"` // 生成的文件 (小说的 txt / epub)
+	Work      *Work       `json:"work,omitempty"`  // 作品完整元数据
+	// 收藏模式下作品来自多个作者, 记录是谁收藏的
+	BookmarkedBy string `json:"bookmarked_by,omitempty"`
+	Rank         int    `json:"rank,omitempty"`      // 排行榜名次
+	RankDate     string `json:"rank_date,omitempty"` // 排行榜日期 (YYYYMMDD)
+}
+
+// WorkRef 列表中发现的作品 (获取详情之前)
+type WorkRef struct {
+	ID         string `json:"id"`
+	Kind       string `json:"kind"`                  // illust, novel
+	Category   string `json:"category,omitempty"`    // illust, manga, 列表未提供时为空
+	UpdateDate string `json:"update_date,omitempty"` // 列表未提供时为空
+	Rank       int    `json:"rank,omitempty"`        // 排行榜名次
+	RankDate   string `json:"rank_date,omitempty"`
+}
+
+// Key 作品在任务中的唯一标识 (插画和小说的 ID 可能重复)
+func (r WorkRef) Key() string {
+	return r.Kind + ":" + r.ID
+}
+
+// TaskStats 任务统计 (与已归档作品对比)
+type TaskStats struct {
+	New     int `json:"new"`     // 首次归档的作品
+	Updated int `json:"updated"` // 作者更新过, 重新下载的作品
+	Skipped int `json:"skipped"` // 已归档且未变化, 跳过的作品
+	Matched int `json:"matched"` // 通过过滤条件的作品
+	// 被过滤掉的作品数, 按原因 (date, excluded_tag, missing_tag, x_restrict, ai, bookmarks, likes, illust_type, max_works)
+	Filtered map[string]int `json:"filtered,omitempty"`
 }
 
 // TaskStatusResponse 任务状态响应
 type TaskStatusResponse struct {
-	Status   string       `json:"status"` // running, completed, failed
-	Mode     string       `json:"mode"`   // image, data
+	Status   string       `json:"status"` // running, paused, interrupted, completed, cancelled, failed
+	Mode     string       `json:"mode"`   // image, data, novel, bookmarks, search, ranking
+	Scope    string       `json:"scope"`  // user, search, ranking
+	Target   string       `json:"target"` // 用户 ID, 搜索词或排行榜
 	UserInfo UserInfo     `json:"user_info"`
 	Logs     []string     `json:"logs"`
+	Stats    TaskStats    `json:"stats"`
 	Results  []TaskResult `json:"results,omitempty"`
 	Images   []ImageInfo  `json:"images,omitempty"`
+	// 任务失败的原因, 如 auth_expired (登录已失效)
+	ErrorCode string `json:"error_code,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// AuthStatus Cookie 对应的登录状态
+type AuthStatus struct {
+	LoggedIn bool   `json:"logged_in"`
+	UserID   string `json:"user_id,omitempty"` // 登录用户的 ID
+	UserName string `json:"user_name,omitempty"`
+	Premium  bool   `json:"premium"`
+	R18      bool   `json:"r18"`  // 是否开启了 R-18 作品显示
+	R18G     bool   `json:"r18g"` // 是否开启了 R-18G 作品显示
+}
+
+// AccountInfo 本地账号库中的账号, Cookie 已脱敏
+type AccountInfo struct {
+	Alias     string `json:"alias"`
+	UserID    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	Premium   bool   `json:"premium"`
+	Cookie    string `json:"cookie"` // 只保留 Cookie 名称, 如 PHPSESSID=***
+	AddedAt   string `json:"added_at"`
+	CheckedAt string `json:"checked_at,omitempty"` // 最近一次测试的时间
+	Status    string `json:"status,omitempty"`     // 最近一次测试的结果: ok, auth_expired, r18_disabled, error
 }
 
 // LogResponse 日志响应
@@ -57,9 +251,30 @@ type LogResponse struct {
 
 // HealthResponse 健康检查响应
 type HealthResponse struct {
-	Status     string `json:"status"`
-	BaseDir    string `json:"base_dir"`
-	TasksCount int    `json:"tasks_count"`
+	Status     string         `json:"status"`
+	BaseDir    string         `json:"base_dir"`
+	TasksCount int            `json:"tasks_count"`
+	Accounts   []AccountUsage `json:"accounts"`          // 客户端启动后用过的账号
+	Proxies    []ProxyStatus  `json:"proxies,omitempty"` // 代理池状态, 未配置代理时为空
+}
+
+// AccountUsage 账号的请求统计
+type AccountUsage struct {
+	Alias         string `json:"alias"`
+	Requests      int    `json:"requests"`
+	Throttled     int    `json:"throttled"`                // 被 403 / 429 拒绝的请求数
+	CooldownUntil string `json:"cooldown_until,omitempty"` // 冷却中的账号暂不使用
+	LastUsed      string `json:"last_used"`
+}
+
+// ProxyStatus 代理的健康状态
+type ProxyStatus struct {
+	URL       string `json:"url"` // 密码已隐藏
+	Healthy   bool   `json:"healthy"`
+	Requests  int    `json:"requests"`
+	Failures  int    `json:"failures"`             // 连续失败次数
+	DownUntil string `json:"down_until,omitempty"` // 连续失败后暂停使用, 到期后重新尝试
+	LastError string `json:"last_error,omitempty"`
 }
 
 // ConfigResponse 配置响应
@@ -68,10 +283,22 @@ type ConfigResponse struct {
 	Client ClientConfig `json:"client"`
 }
 
+// RateLimits 全局请求限速, 所有任务共享
+type RateLimits struct {
+	MaxConcurrent int     `json:"max_concurrent"` // 同时进行的请求数上限, 0 表示不限制
+	PixivRate     float64 `json:"pixiv_rate"`     // www.pixiv.net 每秒请求数, 0 表示不限制
+	PixivBurst    int     `json:"pixiv_burst"`
+	PximgRate     float64 `json:"pximg_rate"` // i.pximg.net 每秒请求数, 0 表示不限制
+	PximgBurst    int     `json:"pximg_burst"`
+	InFlight      int     `json:"in_flight,omitempty"` // 当前进行中的请求数 (只读)
+}
+
 type UserConfig struct {
-	ServerURL string `json:"server_url"`
-	ProxyHost string `json:"proxy_host"`
-	ProxyPort int    `json:"proxy_port"`
+	ServerURL   string   `json:"server_url"`
+	ProxyMode   string   `json:"proxy_mode"` // none, env, http, https, socks5
+	ProxyHost   string   `json:"proxy_host"`
+	ProxyPort   int      `json:"proxy_port"`
+	ProxyBypass []string `json:"proxy_bypass,omitempty"`
 }
 
 type ClientConfig struct {
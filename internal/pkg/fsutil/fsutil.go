@@ -0,0 +1,68 @@
+package fsutil
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxNameBytes is the longest path component SanitizeName produces. File systems allow 255 bytes,
+// the rest is left for collision suffixes and the .part extension of downloads
+const MaxNameBytes = 200
+
+// Device names Windows reserves in every directory, with or without an extension
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeName turns an arbitrary string (search word, title...) into a single safe path component.
+// The result is NFC normalized, valid on Windows and at most MaxNameBytes long, keeping its extension
+func SanitizeName(name string) string {
+	name = norm.NFC.String(strings.ToValidUTF8(name, "_"))
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+
+	// Windows does not allow trailing dots or spaces
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" {
+		return "_"
+	}
+
+	stem, ext := name, ""
+	if i := strings.LastIndexByte(name, '.'); i > 0 && len(name)-i <= 16 {
+		stem, ext = name[:i], name[i:]
+	}
+	// The device name is everything before the first dot: con.tar.gz is reserved as well
+	if device, _, _ := strings.Cut(name, "."); windowsReserved[strings.ToUpper(strings.TrimRight(device, " "))] {
+		stem = "_" + stem
+	}
+	if len(stem)+len(ext) > MaxNameBytes {
+		stem = strings.TrimRight(TruncateBytes(stem, MaxNameBytes-len(ext)), ". ")
+		if stem == "" {
+			stem = "_"
+		}
+	}
+	return stem + ext
+}
+
+// TruncateBytes shortens s to at most n bytes without cutting a character in half
+func TruncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
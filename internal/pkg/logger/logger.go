@@ -31,6 +31,10 @@ func (l *TaskLogger) Info(format string, v ...any) {
 	l.log("INFO", format, v...)
 }
 
+func (l *TaskLogger) Warn(format string, v ...any) {
+	l.log("WARN", format, v...)
+}
+
 func (l *TaskLogger) Error(format string, v ...any) {
 	l.log("ERROR", format, v...)
 }
@@ -0,0 +1,139 @@
+package preview
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+)
+
+// Quality is the JPEG quality of generated previews
+const Quality = 85
+
+// Flatten copies an image onto a white background, at full resolution
+func Flatten(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, b.Min, draw.Over)
+	return flat
+}
+
+// Scale scales a flattened image down so that its longest side is at most maxSide, smaller images are
+// returned as they are. Several sizes are cheapest made from the largest down, each from the previous one
+func Scale(flat *image.RGBA, maxSide int) *image.RGBA {
+	w, h := flat.Rect.Dx(), flat.Rect.Dy()
+	if w <= maxSide && h <= maxSide {
+		return flat
+	}
+	if w >= h {
+		return resizeArea(flat, maxSide, max(1, h*maxSide/w))
+	}
+	return resizeArea(flat, max(1, w*maxSide/h), maxSide)
+}
+
+// resizeArea downscales with a box filter, first along x then along y.
+// Every source pixel contributes to a target pixel in proportion to the area it covers.
+// Rows are scaled one at a time, so the only buffers besides the target are two rows of dw pixels
+func resizeArea(src *image.RGBA, dw, dh int) *image.RGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	xw := weights(w, dw)
+	yw := weights(h, dh)
+
+	// Horizontal pass of one source row: w -> dw, 3 channels as floats. A source row on the border of
+	// two target rows is used by both, the last one is kept
+	row := make([]float32, dw*3)
+	rowY := -1
+	scaleRow := func(y int) {
+		if y == rowY {
+			return
+		}
+		rowY = y
+		pix := src.Pix[y*src.Stride:]
+		for dx, ws := range xw {
+			var r, g, bl float32
+			for _, wt := range ws {
+				p := pix[wt.index*4:]
+				r += float32(p[0]) * wt.weight
+				g += float32(p[1]) * wt.weight
+				bl += float32(p[2]) * wt.weight
+			}
+			row[dx*3], row[dx*3+1], row[dx*3+2] = r, g, bl
+		}
+	}
+
+	// Vertical pass: every target row sums the scaled source rows it covers
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	acc := make([]float32, dw*3)
+	for dy, ws := range yw {
+		clear(acc)
+		for _, wt := range ws {
+			scaleRow(wt.index)
+			for i, v := range row {
+				acc[i] += v * wt.weight
+			}
+		}
+		out := dst.Pix[dy*dst.Stride:]
+		for dx := 0; dx < dw; dx++ {
+			p := out[dx*4:]
+			p[0], p[1], p[2], p[3] = clamp(acc[dx*3]), clamp(acc[dx*3+1]), clamp(acc[dx*3+2]), 0xff
+		}
+	}
+	return dst
+}
+
+type weight struct {
+	index  int
+	weight float32
+}
+
+// weights returns, for every target position, the source positions it covers and their share
+func weights(srcLen, dstLen int) [][]weight {
+	scale := float64(srcLen) / float64(dstLen)
+	all := make([][]weight, dstLen)
+	for d := range all {
+		start, end := float64(d)*scale, float64(d+1)*scale
+		for s := int(start); s < srcLen && float64(s) < end; s++ {
+			cover := min(end, float64(s+1)) - max(start, float64(s))
+			if cover > 0 {
+				all[d] = append(all[d], weight{index: s, weight: float32(cover / scale)})
+			}
+		}
+	}
+	return all
+}
+
+func clamp(v float32) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// WriteJPEG saves an image as JPEG, writing to a temporary file first so a preview is never half written
+func WriteJPEG(img image.Image, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = jpeg.Encode(f, img, &jpeg.Options{Quality: Quality})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
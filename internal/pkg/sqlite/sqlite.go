@@ -0,0 +1,415 @@
+// Package sqlite reads the rows of tables from an SQLite 3 database file, without cgo or dependencies.
+// It only supports what reading a small browser database takes: table b-trees, overflow pages and
+// committed frames of a write-ahead log. Indexes, views and every kind of write are left out
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var ErrNotDatabase = errors.New("sqlite: not an SQLite 3 database")
+
+// Page types of b-tree pages
+const (
+	interiorTable = 0x05
+	leafTable     = 0x0d
+)
+
+const (
+	// minUsable is the smallest usable page size SQLite allows, smaller ones come from a corrupt header
+	minUsable = 480
+	// maxPayload bounds a row read from the file, cookies and schema rows are far smaller
+	maxPayload = 16 << 20
+)
+
+// DB is a database loaded in memory
+type DB struct {
+	data     []byte
+	pageSize int
+	usable   int            // page size minus the reserved bytes at the end of every page
+	wal      map[int][]byte // newest committed version of pages found in the write-ahead log
+}
+
+// Open parses a database file. wal is the content of its -wal file, nil when there is none
+func Open(data []byte, wal []byte) (*DB, error) {
+	if len(data) < 100 || !bytes.HasPrefix(data, []byte("SQLite format 3\x00")) {
+		return nil, ErrNotDatabase
+	}
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("sqlite: invalid page size %d", pageSize)
+	}
+	db := &DB{
+		data:     data,
+		pageSize: pageSize,
+		usable:   pageSize - int(data[20]),
+	}
+	if db.usable < minUsable {
+		return nil, fmt.Errorf("sqlite: invalid reserved space %d", data[20])
+	}
+	if wal != nil {
+		db.wal = readWAL(wal, pageSize)
+	}
+	return db, nil
+}
+
+// readWAL returns the pages of the committed transactions of a write-ahead log. Frames after the last
+// commit, or left over from an older log (other salts), are ignored
+func readWAL(wal []byte, pageSize int) map[int][]byte {
+	const headerSize, frameHeaderSize = 32, 24
+	if len(wal) < headerSize {
+		return nil
+	}
+	magic := binary.BigEndian.Uint32(wal[0:4])
+	if magic != 0x377f0682 && magic != 0x377f0683 || int(binary.BigEndian.Uint32(wal[8:12])) != pageSize {
+		return nil
+	}
+	salt := wal[16:24]
+
+	pages := make(map[int][]byte)
+	pending := make(map[int][]byte)
+	for off := headerSize; off+frameHeaderSize+pageSize <= len(wal); off += frameHeaderSize + pageSize {
+		frame := wal[off : off+frameHeaderSize]
+		if !bytes.Equal(frame[8:16], salt) {
+			break
+		}
+		pageNo := int(binary.BigEndian.Uint32(frame[0:4]))
+		pending[pageNo] = wal[off+frameHeaderSize : off+frameHeaderSize+pageSize]
+		// Only a commit frame, which carries the database size, makes the frames before it visible
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			for no, page := range pending {
+				pages[no] = page
+			}
+			clear(pending)
+		}
+	}
+	return pages
+}
+
+// page returns page n (starting at 1)
+func (db *DB) page(n int) ([]byte, error) {
+	if page, ok := db.wal[n]; ok {
+		return page, nil
+	}
+	start := (n - 1) * db.pageSize
+	if n < 1 || start+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("sqlite: page %d out of range", n)
+	}
+	return db.data[start : start+db.pageSize], nil
+}
+
+// Row is a table row, values are nil, int64, float64, string or []byte
+type Row struct {
+	RowID  int64
+	Values []any
+}
+
+// Table is the schema of a table
+type Table struct {
+	Name     string
+	RootPage int
+	Columns  []string
+}
+
+// Column returns the value of a named column, nil when the row predates the column
+func (t *Table) Column(row Row, name string) any {
+	for i, col := range t.Columns {
+		if strings.EqualFold(col, name) {
+			if i < len(row.Values) {
+				return row.Values[i]
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Table looks a table up in the schema
+func (db *DB) Table(name string) (*Table, error) {
+	schema := &Table{Name: "sqlite_master", RootPage: 1}
+	var table *Table
+	err := db.walk(schema.RootPage, make(map[int]bool), func(row Row) error {
+		if len(row.Values) < 5 || row.Values[0] != "table" {
+			return nil
+		}
+		tblName, _ := row.Values[1].(string)
+		if !strings.EqualFold(tblName, name) {
+			return nil
+		}
+		rootPage, _ := row.Values[3].(int64)
+		sql, _ := row.Values[4].(string)
+		table = &Table{Name: tblName, RootPage: int(rootPage), Columns: parseColumns(sql)}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if table == nil {
+		return nil, fmt.Errorf("sqlite: no such table: %s", name)
+	}
+	return table, nil
+}
+
+// Rows returns every row of a table in rowid order
+func (db *DB) Rows(table *Table) ([]Row, error) {
+	var rows []Row
+	err := db.walk(table.RootPage, make(map[int]bool), func(row Row) error {
+		rows = append(rows, row)
+		return nil
+	})
+	return rows, err
+}
+
+// walk visits the rows of the table b-tree rooted at page n. seen holds the pages already visited,
+// a corrupt file could link a page from several places or loop back to it
+func (db *DB) walk(n int, seen map[int]bool, visit func(Row) error) error {
+	if seen[n] {
+		return fmt.Errorf("sqlite: page %d is linked twice", n)
+	}
+	seen[n] = true
+
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	header := page
+	if n == 1 {
+		header = page[100:]
+	}
+
+	headerSize := 8
+	if header[0] == interiorTable {
+		headerSize = 12
+	}
+	cells := int(binary.BigEndian.Uint16(header[3:5]))
+	if headerSize+2*cells > len(header) {
+		return fmt.Errorf("sqlite: page %d has too many cells (%d)", n, cells)
+	}
+	pointers := header[headerSize : headerSize+2*cells]
+
+	switch header[0] {
+	case leafTable:
+		for i := 0; i < cells; i++ {
+			row, err := db.leafCell(page, int(binary.BigEndian.Uint16(pointers[2*i:])))
+			if err != nil {
+				return err
+			}
+			if err := visit(row); err != nil {
+				return err
+			}
+		}
+	case interiorTable:
+		for i := 0; i < cells; i++ {
+			offset := int(binary.BigEndian.Uint16(pointers[2*i:]))
+			if offset+4 > len(page) {
+				return errors.New("sqlite: cell out of page")
+			}
+			if err := db.walk(int(binary.BigEndian.Uint32(page[offset:])), seen, visit); err != nil {
+				return err
+			}
+		}
+		return db.walk(int(binary.BigEndian.Uint32(header[8:12])), seen, visit)
+	default:
+		return fmt.Errorf("sqlite: page %d is not a table page (type %d)", n, header[0])
+	}
+	return nil
+}
+
+// leafCell decodes the cell of a table leaf page, following its overflow pages
+func (db *DB) leafCell(page []byte, offset int) (Row, error) {
+	if offset >= len(page) {
+		return Row{}, errors.New("sqlite: cell out of page")
+	}
+	size, n := varint(page[offset:])
+	if n == 0 {
+		return Row{}, errors.New("sqlite: cell out of page")
+	}
+	offset += n
+	rowID, n := varint(page[offset:])
+	if n == 0 {
+		return Row{}, errors.New("sqlite: cell out of page")
+	}
+	offset += n
+
+	// A row cannot be larger than the file, a corrupt size must not allocate gigabytes
+	if size > maxPayload || size > uint64(len(db.data)+len(db.wal)*db.pageSize) {
+		return Row{}, fmt.Errorf("sqlite: row of %d bytes", size)
+	}
+	payloadSize := int(size)
+	local := db.localPayload(payloadSize)
+	if offset+local > len(page) {
+		return Row{}, errors.New("sqlite: cell out of page")
+	}
+	payload := make([]byte, 0, payloadSize)
+	payload = append(payload, page[offset:offset+local]...)
+
+	if local < payloadSize {
+		if offset+local+4 > len(page) {
+			return Row{}, errors.New("sqlite: cell out of page")
+		}
+		next := int(binary.BigEndian.Uint32(page[offset+local:]))
+		// Every overflow page adds usable-4 bytes, a looping chain ends once the payload is full
+		for next != 0 && len(payload) < payloadSize {
+			overflow, err := db.page(next)
+			if err != nil {
+				return Row{}, err
+			}
+			chunk := overflow[4:db.usable]
+			payload = append(payload, chunk[:min(len(chunk), payloadSize-len(payload))]...)
+			next = int(binary.BigEndian.Uint32(overflow[0:4]))
+		}
+		if len(payload) < payloadSize {
+			return Row{}, errors.New("sqlite: truncated overflow chain")
+		}
+	}
+
+	values, err := record(payload)
+	if err != nil {
+		return Row{}, err
+	}
+	return Row{RowID: int64(rowID), Values: values}, nil
+}
+
+// localPayload is how much of a payload is stored in a table leaf cell, the rest goes to overflow pages
+func (db *DB) localPayload(size int) int {
+	maxLocal := db.usable - 35
+	if size <= maxLocal {
+		return size
+	}
+	minLocal := (db.usable-12)*32/255 - 23
+	k := minLocal + (size-minLocal)%(db.usable-4)
+	if k <= maxLocal {
+		return k
+	}
+	return minLocal
+}
+
+// record decodes the values of a record
+func record(payload []byte) ([]any, error) {
+	headerSize, n := varint(payload)
+	if n == 0 || headerSize < uint64(n) || headerSize > uint64(len(payload)) {
+		return nil, errors.New("sqlite: corrupt record")
+	}
+	types := payload[n:headerSize]
+	body := payload[headerSize:]
+
+	var values []any
+	for len(types) > 0 {
+		serial, n := varint(types)
+		if n == 0 {
+			return nil, errors.New("sqlite: corrupt record")
+		}
+		types = types[n:]
+
+		var size uint64
+		switch {
+		case serial == 0 || serial == 8 || serial == 9:
+			size = 0
+		case serial <= 4:
+			size = serial
+		case serial == 5:
+			size = 6
+		case serial == 6 || serial == 7:
+			size = 8
+		case serial >= 12:
+			size = (serial - 12) / 2
+		default:
+			return nil, fmt.Errorf("sqlite: invalid serial type %d", serial)
+		}
+		if size > uint64(len(body)) {
+			return nil, errors.New("sqlite: corrupt record")
+		}
+		field := body[:size]
+		body = body[size:]
+
+		switch {
+		case serial == 0:
+			values = append(values, nil)
+		case serial == 8:
+			values = append(values, int64(0))
+		case serial == 9:
+			values = append(values, int64(1))
+		case serial <= 6:
+			// Big-endian two's complement of 1 to 8 bytes
+			v := int64(int8(field[0]))
+			for _, b := range field[1:] {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case serial == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(field)))
+		case serial%2 == 0:
+			values = append(values, append([]byte(nil), field...))
+		default:
+			values = append(values, string(field))
+		}
+	}
+	return values, nil
+}
+
+// varint decodes an SQLite varint: up to 8 bytes of 7 bits, then a last byte of 8 bits.
+// It returns 0 bytes read when buf ends too early
+func varint(buf []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(buf) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(buf[i]), 9
+		}
+		v = v<<7 | uint64(buf[i]&0x7f)
+		if buf[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
+
+// parseColumns extracts the column names of a CREATE TABLE statement, in order
+func parseColumns(sql string) []string {
+	start := strings.Index(sql, "(")
+	end := strings.LastIndex(sql, ")")
+	if start < 0 || end <= start {
+		return nil
+	}
+
+	var defs []string
+	depth, last := 0, start+1
+	for i := start + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, sql[last:i])
+				last = i + 1
+			}
+		}
+	}
+	defs = append(defs, sql[last:end])
+
+	columns := make([]string, 0, len(defs))
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		columns = append(columns, strings.Trim(fields[0], "\"`[]'"))
+	}
+	return columns
+}
@@ -0,0 +1,271 @@
+package sqlite
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testdata/cookies.sqlite is a Firefox cookie database with 1 KB pages, built by testdata/make_fixtures.py.
+// Its -wal file holds a commit made after the last checkpoint: PHPSESSID changed and device_token added
+
+func readFixtures(t *testing.T) (data []byte, wal []byte) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/cookies.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal, err = os.ReadFile("testdata/cookies.sqlite-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, wal
+}
+
+// cookies reads moz_cookies as name/originAttributes -> value
+func cookies(t *testing.T, data, wal []byte) (map[string]string, error) {
+	t.Helper()
+	db, err := Open(data, wal)
+	if err != nil {
+		return nil, err
+	}
+	table, err := db.Table("moz_cookies")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Rows(table)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(rows))
+	for _, row := range rows {
+		name, _ := table.Column(row, "name").(string)
+		origin, _ := table.Column(row, "originAttributes").(string)
+		value, _ := table.Column(row, "value").(string)
+		values[name+origin] = value
+	}
+	return values, nil
+}
+
+func TestReadFirefoxCookies(t *testing.T) {
+	data, wal := readFixtures(t)
+
+	tests := []struct {
+		name     string
+		wal      []byte
+		rows     int
+		session  string
+		hasToken bool
+	}{
+		{name: "database only", wal: nil, rows: 405, session: "12345678_oldtoken"},
+		{name: "with write-ahead log", wal: wal, rows: 406, session: "12345678_newtoken", hasToken: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := cookies(t, data, tt.wal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(values) != tt.rows {
+				t.Errorf("rows = %d, want %d", len(values), tt.rows)
+			}
+			if got := values["PHPSESSID"]; got != tt.session {
+				t.Errorf("PHPSESSID = %q, want %q", got, tt.session)
+			}
+			if got := values["PHPSESSID^userContextId=2"]; got != "999_container" {
+				t.Errorf("container PHPSESSID = %q", got)
+			}
+			if _, ok := values["device_token"]; ok != tt.hasToken {
+				t.Errorf("device_token present = %v, want %v", ok, tt.hasToken)
+			}
+			// Spills over several overflow pages
+			if got := values["long"]; got != strings.Repeat("x", 5000) {
+				t.Errorf("long value has %d bytes", len(got))
+			}
+			if got := values["filler399"]; got != strings.Repeat("v", 399%40) {
+				t.Errorf("filler399 = %q", got)
+			}
+		})
+	}
+}
+
+func TestColumns(t *testing.T) {
+	data, _ := readFixtures(t)
+	db, err := Open(data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := db.Table("moz_cookies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"id", "originAttributes", "name", "value", "host", "path", "expiry", "lastAccessed",
+		"creationTime", "isSecure", "isHttpOnly", "inBrowserElement", "sameSite", "rawSameSite", "schemeMap",
+		"isPartitionedAttributeSet"}
+	if strings.Join(table.Columns, ",") != strings.Join(want, ",") {
+		t.Errorf("columns = %v", table.Columns)
+	}
+	if _, err := db.Table("moz_missing"); err == nil {
+		t.Error("missing table found")
+	}
+}
+
+// Frames after the last commit belong to a transaction still in progress and must be ignored
+func TestUncommittedFrames(t *testing.T) {
+	data, wal := readFixtures(t)
+	pageSize := int(binary.BigEndian.Uint32(wal[8:12]))
+
+	frame := make([]byte, 24+pageSize)
+	binary.BigEndian.PutUint32(frame[0:4], 2) // root page of moz_cookies, overwritten with garbage
+	copy(frame[8:16], wal[16:24])             // salts of the log, commit size left at 0
+	for i := 24; i < len(frame); i++ {
+		frame[i] = 0xff
+	}
+
+	values, err := cookies(t, data, append(append([]byte(nil), wal...), frame...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["PHPSESSID"] != "12345678_newtoken" {
+		t.Errorf("PHPSESSID = %q", values["PHPSESSID"])
+	}
+}
+
+func TestNotDatabase(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("# Netscape HTTP Cookie File\n"), make([]byte, 4096)} {
+		if _, err := Open(data, nil); err != ErrNotDatabase {
+			t.Errorf("Open(%d bytes) = %v, want ErrNotDatabase", len(data), err)
+		}
+	}
+}
+
+// Corrupt files must fail with an error, never panic or hang: the user picks the file
+func TestCorruptFiles(t *testing.T) {
+	data, wal := readFixtures(t)
+
+	set := func(offset int, b ...byte) func([]byte) []byte {
+		return func(d []byte) []byte {
+			copy(d[offset:], b)
+			return d
+		}
+	}
+	tests := []struct {
+		name    string
+		corrupt func([]byte) []byte
+	}{
+		{"truncated header", func(d []byte) []byte { return d[:99] }},
+		{"truncated first page", func(d []byte) []byte { return d[:500] }},
+		{"truncated table", func(d []byte) []byte { return d[:3*1024] }},
+		{"truncated last page", func(d []byte) []byte { return d[:len(d)-1] }},
+		{"page size not a power of two", set(16, 0x03, 0x00)},
+		{"reserved space larger than the page", set(20, 0xff)},
+		// Page 1 is the leaf of the schema, page 2 the interior root page of moz_cookies
+		{"schema cell count past the page", set(103, 0xff, 0xff)},
+		{"schema cell pointer past the page", set(108, 0xff, 0xff)},
+		{"unknown page type", set(1024, 0x02)},
+		{"cell count past the page", set(1027, 0xff, 0xff)},
+		{"child page out of range", set(1032, 0x7f, 0xff, 0xff, 0xff)},
+		{"child page pointing to its parent", set(1032, 0x00, 0x00, 0x00, 0x02)},
+		{"child pointer past the page", set(1036, 0xff, 0xff)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			corrupt := tt.corrupt(append([]byte(nil), data...))
+			if _, err := cookies(t, corrupt, nil); err == nil {
+				t.Error("corrupt file read without error")
+			}
+		})
+	}
+
+	// Truncated and partly overwritten logs: committed frames left intact must still read
+	for _, n := range []int{0, 31, 32, 100, len(wal) - 1} {
+		if _, err := cookies(t, data, wal[:n]); err != nil {
+			t.Errorf("wal truncated to %d bytes: %v", n, err)
+		}
+	}
+}
+
+// Cells, records and varints of every page overwritten with random bytes
+func TestRandomCorruption(t *testing.T) {
+	data, wal := readFixtures(t)
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 2000; i++ {
+		corrupt := append([]byte(nil), data...)
+		corruptWAL := append([]byte(nil), wal...)
+		target := corrupt
+		if i%4 == 0 {
+			target = corruptWAL
+		}
+		for n := 1 + rng.Intn(8); n > 0; n-- {
+			// Keep the magic string, a file without it is rejected before any parsing
+			offset := 16 + rng.Intn(len(target)-16)
+			target[offset] = byte(rng.Intn(256))
+		}
+		cookies(t, corrupt, corruptWAL)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []any
+		wantErr bool
+	}{
+		{name: "integers and text", payload: []byte{4, 1, 9, 0x13, 0xfe, 'a', 'b', 'c'}, want: []any{int64(-2), int64(1), "abc"}},
+		{name: "null and blob", payload: []byte{3, 0, 0x10, 0xca, 0xfe}, want: []any{nil, []byte{0xca, 0xfe}}},
+		{name: "empty", payload: nil, wantErr: true},
+		{name: "header size past the payload", payload: []byte{9, 1}, wantErr: true},
+		{name: "header size smaller than its varint", payload: []byte{0x80, 0x01, 1}, wantErr: true},
+		{name: "truncated serial type varint", payload: []byte{2, 0x81}, wantErr: true},
+		{name: "reserved serial type", payload: []byte{2, 10}, wantErr: true},
+		{name: "text past the payload", payload: []byte{2, 0x7f, 'a'}, wantErr: true},
+		{name: "huge serial type", payload: []byte{10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := record(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("record() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("record() = %#v, want %#v", got, tt.want)
+			}
+			for i := range got {
+				if gb, ok := got[i].([]byte); ok {
+					if string(gb) != string(tt.want[i].([]byte)) {
+						t.Errorf("value %d = %#v, want %#v", i, got[i], tt.want[i])
+					}
+				} else if got[i] != tt.want[i] {
+					t.Errorf("value %d = %#v, want %#v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestVarint(t *testing.T) {
+	tests := []struct {
+		buf  []byte
+		want uint64
+		n    int
+	}{
+		{[]byte{0x05}, 5, 1},
+		{[]byte{0x81, 0x00}, 128, 2},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, 1<<64 - 1, 9},
+		{[]byte{0x81}, 0, 0},
+		{nil, 0, 0},
+	}
+	for _, tt := range tests {
+		got, n := varint(tt.buf)
+		if got != tt.want || n != tt.n {
+			t.Errorf("varint(%x) = %d, %d, want %d, %d", tt.buf, got, n, tt.want, tt.n)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+#!/usr/bin/env python3
+"""Builds cookies.sqlite and cookies.sqlite-wal, a Firefox cookie database caught while Firefox runs:
+the latest login is only in the write-ahead log. Run from this directory."""
+import os
+import shutil
+import sqlite3
+import tempfile
+
+# Schema of moz_cookies in current Firefox profiles
+SCHEMA = """CREATE TABLE moz_cookies (id INTEGER PRIMARY KEY, originAttributes TEXT NOT NULL DEFAULT '', name TEXT,
+value TEXT, host TEXT, path TEXT, expiry INTEGER, lastAccessed INTEGER, creationTime INTEGER, isSecure INTEGER,
+isHttpOnly INTEGER, inBrowserElement INTEGER DEFAULT 0, sameSite INTEGER DEFAULT 0, rawSameSite INTEGER DEFAULT 0,
+schemeMap INTEGER DEFAULT 0, isPartitionedAttributeSet INTEGER DEFAULT 0,
+CONSTRAINT moz_uniqueid UNIQUE (name, host, path, originAttributes))"""
+
+FAR = 4102444800  # 2100-01-01
+INSERT = ("INSERT INTO moz_cookies (originAttributes, name, value, host, path, expiry, lastAccessed, creationTime, "
+          "isSecure, isHttpOnly) VALUES (?, ?, ?, ?, '/', ?, 0, 0, ?, ?)")
+
+tmp = tempfile.mkdtemp()
+path = os.path.join(tmp, "cookies.sqlite")
+db = sqlite3.connect(path, isolation_level=None)
+# Small pages: the table spans interior pages and long values spill to overflow pages
+db.execute("PRAGMA page_size = 1024")
+db.execute("PRAGMA journal_mode = WAL")
+db.execute("PRAGMA wal_autocheckpoint = 0")
+db.execute(SCHEMA)
+
+db.execute("BEGIN")
+for i in range(400):
+    db.execute(INSERT, ("", "filler%d" % i, "v" * (i % 40), ".site%d.example" % i, FAR, 0, 0))
+db.execute(INSERT, ("", "PHPSESSID", "12345678_oldtoken", ".pixiv.net", FAR, 1, 1))
+db.execute(INSERT, ("", "p_ab_id", "3", ".pixiv.net", FAR * 1000, 1, 0))  # expiry in milliseconds
+db.execute(INSERT, ("", "long", "x" * 5000, ".pixiv.net", FAR, 1, 0))
+db.execute(INSERT, ("", "expired", "1", ".pixiv.net", 1000000000, 1, 0))
+db.execute(INSERT, ("^userContextId=2", "PHPSESSID", "999_container", ".pixiv.net", FAR, 1, 1))
+db.execute("COMMIT")
+db.execute("PRAGMA wal_checkpoint(TRUNCATE)")
+
+# Left in the log only
+db.execute("UPDATE moz_cookies SET value = '12345678_newtoken' WHERE name = 'PHPSESSID' AND originAttributes = ''")
+db.execute(INSERT, ("", "device_token", "dt", "www.pixiv.net", FAR, 1, 1))
+
+# Copy while the connection is open, closing it would checkpoint the log
+shutil.copy(path, "cookies.sqlite")
+shutil.copy(path + "-wal", "cookies.sqlite-wal")
+db.close()
+shutil.rmtree(tmp)
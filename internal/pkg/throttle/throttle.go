@@ -0,0 +1,201 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limit is the request rate allowed for a host
+type Limit struct {
+	Rate  float64 // requests per second, 0 means unlimited
+	Burst int     // requests allowed at once after an idle period
+}
+
+// Scheduler spaces out requests with a token bucket per host and caps the requests in flight across all hosts.
+// Limits can be changed while requests are waiting
+type Scheduler struct {
+	mu            sync.Mutex
+	maxConcurrent int // 0 means unlimited
+	inFlight      int
+	wake          chan struct{} // closed whenever a slot may have become free
+	limits        map[string]Limit
+	buckets       map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a scheduler, hosts missing from limits are only subject to the concurrency cap
+func New(maxConcurrent int, limits map[string]Limit) *Scheduler {
+	s := &Scheduler{
+		wake:    make(chan struct{}),
+		buckets: make(map[string]*bucket),
+	}
+	s.SetLimits(maxConcurrent, limits)
+	return s
+}
+
+// SetLimits replaces the limits, waiting requests pick them up right away
+func (s *Scheduler) SetLimits(maxConcurrent int, limits map[string]Limit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxConcurrent = maxConcurrent
+	s.limits = make(map[string]Limit, len(limits))
+	for host, limit := range limits {
+		s.limits[host] = limit
+		// Keep the current fill of existing buckets, only clamp it to the new burst
+		if b, ok := s.buckets[host]; ok && b.tokens > float64(max(limit.Burst, 1)) {
+			b.tokens = float64(max(limit.Burst, 1))
+		}
+	}
+	s.broadcast()
+}
+
+// Limits returns the current limits
+func (s *Scheduler) Limits() (int, map[string]Limit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limits := make(map[string]Limit, len(s.limits))
+	for host, limit := range s.limits {
+		limits[host] = limit
+	}
+	return s.maxConcurrent, limits
+}
+
+// InFlight returns how many requests currently hold a slot
+func (s *Scheduler) InFlight() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight
+}
+
+// Acquire waits for a token of host and a free slot. The returned function gives the slot back
+func (s *Scheduler) Acquire(ctx context.Context, host string) (func(), error) {
+	if err := s.waitToken(ctx, host); err != nil {
+		return nil, err
+	}
+	if err := s.acquireSlot(ctx); err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() { once.Do(s.releaseSlot) }, nil
+}
+
+func (s *Scheduler) waitToken(ctx context.Context, host string) error {
+	for {
+		s.mu.Lock()
+		limit, ok := s.limits[host]
+		if !ok || limit.Rate <= 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		burst := float64(max(limit.Burst, 1))
+		b, ok := s.buckets[host]
+		if !ok {
+			b = &bucket{tokens: burst, last: time.Now()}
+			s.buckets[host] = b
+		}
+
+		now := time.Now()
+		b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			s.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+		wake := s.wake
+		s.mu.Unlock()
+
+		// Wake up early when the limits change, the new rate may allow the request sooner
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Scheduler) acquireSlot(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.maxConcurrent <= 0 || s.inFlight < s.maxConcurrent {
+			s.inFlight++
+			s.mu.Unlock()
+			return nil
+		}
+		wake := s.wake
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+func (s *Scheduler) releaseSlot() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	s.broadcast()
+}
+
+// broadcast wakes every waiter, must be called with mu held
+func (s *Scheduler) broadcast() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// Transport wraps base so every request goes through the scheduler.
+// The slot is held until the response body is closed, so a long download counts as in flight
+func (s *Scheduler) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{scheduler: s, base: base}
+}
+
+type transport struct {
+	scheduler *Scheduler
+	base      http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.scheduler.Acquire(req.Context(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
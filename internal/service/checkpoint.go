@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"go-crawler-client/config"
+	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/pkg/logger"
+)
+
+// checkpointInterval is the minimum time between two checkpoints of a running task
+const checkpointInterval = 5 * time.Second
+
+// taskCheckpoint is what survives a client restart: enough to rebuild the task
+// and continue the crawl without repeating finished works.
+// It is written to <task dir>/.task_data/task_<id>_checkpoint.json and removed once the task ends
+type taskCheckpoint struct {
+	ID          string             `json:"id"`
+	Mode        string             `json:"mode"`
+	Scope       string             `json:"scope"`
+	Target      string             `json:"target"`
+	UserInfo    model.UserInfo     `json:"user_info"`
+	Options     model.TaskOptions  `json:"options"`
+	Guest       bool               `json:"guest,omitempty"`
+	Dir         string             `json:"dir"`
+	ListingDone bool               `json:"listing_done"` // every work of the task is in Pending or Done
+	Pending     []model.WorkRef    `json:"pending"`
+	Done        []string           `json:"done"`
+	Results     []model.TaskResult `json:"results"`
+	Images      []model.ImageInfo  `json:"images"`
+	Stats       model.TaskStats    `json:"stats"`
+	SavedAt     string             `json:"saved_at"`
+}
+
+func checkpointPath(dir string, taskID string) string {
+	return filepath.Join(dir, ".task_data", fmt.Sprintf("task_%s_checkpoint.json", taskID))
+}
+
+// AddPending records a work the crawler is about to fetch
+func (t *Task) AddPending(ref model.WorkRef) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, done := t.done[ref.Key()]; done {
+		return
+	}
+	t.pending[ref.Key()] = ref
+}
+
+// MarkDone records a finished work and checkpoints the task now and then
+func (t *Task) MarkDone(key string) {
+	t.mu.Lock()
+	delete(t.pending, key)
+	t.done[key] = struct{}{}
+	t.mu.Unlock()
+
+	t.Checkpoint(false)
+}
+
+// IsDone reports whether a work was finished, possibly before a restart
+func (t *Task) IsDone(key string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, done := t.done[key]
+	return done
+}
+
+// SetListingDone records that every work of the task has been discovered
+func (t *Task) SetListingDone() {
+	t.mu.Lock()
+	t.listingDone = true
+	t.mu.Unlock()
+
+	t.Checkpoint(true)
+}
+
+// Frontier returns the works still to fetch and whether the listing has to run again to find the rest
+func (t *Task) Frontier() ([]model.WorkRef, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	pending := make([]model.WorkRef, 0, len(t.pending))
+	for _, ref := range t.pending {
+		pending = append(pending, ref)
+	}
+	return pending, t.listingDone
+}
+
+// HeldWork is a work that matched the filter, held back with what it needs to be downloaded
+type HeldWork struct {
+	Ref    model.WorkRef
+	Resume func() // handles the detail of the work again, once it was picked
+}
+
+// HoldMatch holds back a pending work that matched the filter, until TakeHeld picks among the matches
+func (t *Task) HoldMatch(work HeldWork) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.held = append(t.held, work)
+}
+
+// TakeHeld returns the works held back by HoldMatch and forgets them
+func (t *Task) TakeHeld() []HeldWork {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	held := t.held
+	t.held = nil
+	return held
+}
+
+// Select marks a held work as picked, the filter lets it through when its detail is handled again
+func (t *Task) Select(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.selected == nil {
+		t.selected = make(map[string]bool)
+	}
+	t.selected[key] = true
+}
+
+// IsSelected reports whether a held work was picked by Select
+func (t *Task) IsSelected(key string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.selected[key]
+}
+
+// FindImage returns the successfully downloaded image saved at path, if any
+func (t *Task) FindImage(path string) (model.ImageInfo, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, img := range t.Images {
+		if img.Path == path && img.Status == "success" {
+			return img, true
+		}
+	}
+	return model.ImageInfo{}, false
+}
+
+// FindImageOf returns the successfully downloaded file of an illust page and kind
+func (t *Task) FindImageOf(illustID string, page int, kind string) (model.ImageInfo, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, img := range t.Images {
+		if img.IllustID == illustID && img.Page == page && img.Kind == kind && img.Status == "success" {
+			return img, true
+		}
+	}
+	return model.ImageInfo{}, false
+}
+
+// Checkpoint writes the progress of the task to disk. Unless forced, it does nothing
+// when the last checkpoint is more recent than checkpointInterval or one is being written.
+// The task is only locked while its progress is copied, encoding and writing happen after
+func (t *Task) Checkpoint(force bool) {
+	if force {
+		t.checkpointMu.Lock()
+	} else if !t.checkpointMu.TryLock() {
+		return
+	}
+	defer t.checkpointMu.Unlock()
+
+	t.mu.Lock()
+	if !force && time.Since(t.checkpointedAt) < checkpointInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.checkpointedAt = time.Now()
+
+	cp := taskCheckpoint{
+		ID:          t.ID,
+		Mode:        t.Mode,
+		Scope:       t.Scope,
+		Target:      t.Target,
+		UserInfo:    t.UserInfo,
+		Options:     t.Options,
+		Guest:       t.Guest,
+		Dir:         t.Dir,
+		ListingDone: t.listingDone,
+		Pending:     make([]model.WorkRef, 0, len(t.pending)),
+		Done:        make([]string, 0, len(t.done)),
+		Results:     slices.Clone(t.Results),
+		Images:      slices.Clone(t.Images),
+		Stats:       t.Stats,
+		SavedAt:     time.Now().Format(time.RFC3339),
+	}
+	for _, ref := range t.pending {
+		cp.Pending = append(cp.Pending, ref)
+	}
+	for key := range t.done {
+		cp.Done = append(cp.Done, key)
+	}
+	cp.Stats.Filtered = maps.Clone(t.Stats.Filtered)
+	t.mu.Unlock()
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		t.Logger.Error("Failed to encode checkpoint: %v", err)
+		return
+	}
+
+	// Write then rename, a crash mid-write must not destroy the previous checkpoint
+	path := checkpointPath(t.Dir, t.ID)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		t.Logger.Error("Failed to write checkpoint: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		t.Logger.Error("Failed to write checkpoint: %v", err)
+	}
+}
+
+// RemoveCheckpoint deletes the checkpoint of a task that ended
+func (t *Task) RemoveCheckpoint() {
+	os.Remove(checkpointPath(t.Dir, t.ID))
+}
+
+// Resumable reports whether the task can continue from its checkpoint: it was interrupted by a client
+// restart, or failed because of its session (see Fail)
+func (t *Task) Resumable() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.resumable()
+}
+
+func (t *Task) resumable() bool {
+	return t.Status == "interrupted" || (t.Status == "failed" && t.ErrCode != "")
+}
+
+// Reopen turns a resumable task back into a running one, ready for the crawler to continue it
+func (t *Task) Reopen() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.resumable() {
+		return fmt.Errorf("task is %s", t.Status)
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	if t.Status == "failed" {
+		t.Logger.Info("Task resumed after failing (%s)", t.ErrCode)
+	} else {
+		t.Logger.Info("Task resumed after restart")
+	}
+	t.Status = "running"
+	t.ErrCode = ""
+	t.Err = ""
+	return nil
+}
+
+// LoadInterrupted restores the tasks that were still running when the client stopped.
+// They come back as "interrupted" until a resume command restarts them
+func (tm *TaskManager) LoadInterrupted() int {
+	root := filepath.Join(config.GetBaseDir(), "crawl-datas")
+	count := 0
+
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, "_checkpoint.json") {
+			return nil
+		}
+		if filepath.Base(filepath.Dir(path)) != ".task_data" {
+			return nil
+		}
+
+		task, err := loadCheckpoint(path)
+		if err != nil {
+			log.Printf("Warning: failed to load checkpoint %s: %v", path, err)
+			return nil
+		}
+		tm.tasks.Store(task.ID, task)
+		count++
+		return nil
+	})
+
+	return count
+}
+
+func loadCheckpoint(path string) (*Task, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp taskCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, err
+	}
+	if cp.ID == "" {
+		return nil, fmt.Errorf("checkpoint has no task ID")
+	}
+
+	logPath := filepath.Join(cp.Dir, ".task_logs", fmt.Sprintf("task_%s.log", cp.ID))
+	l, err := logger.NewTaskLogger(logPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &Task{
+		ctx:         ctx,
+		cancel:      cancel,
+		ID:          cp.ID,
+		Status:      "interrupted",
+		Mode:        cp.Mode,
+		Scope:       cp.Scope,
+		Target:      cp.Target,
+		UserInfo:    cp.UserInfo,
+		Options:     cp.Options,
+		Guest:       cp.Guest,
+		Dir:         cp.Dir,
+		Logger:      l,
+		Results:     cp.Results,
+		Images:      cp.Images,
+		Stats:       cp.Stats,
+		pending:     make(map[string]model.WorkRef, len(cp.Pending)),
+		done:        make(map[string]struct{}, len(cp.Done)),
+		listingDone: cp.ListingDone,
+	}
+	if task.Results == nil {
+		task.Results = make([]model.TaskResult, 0)
+	}
+	if task.Images == nil {
+		task.Images = make([]model.ImageInfo, 0)
+	}
+	for _, ref := range cp.Pending {
+		task.pending[ref.Key()] = ref
+	}
+	for _, key := range cp.Done {
+		task.done[key] = struct{}{}
+	}
+
+	l.Info("Task restored from checkpoint (%d works done, %d pending)", len(task.done), len(task.pending))
+	return task, nil
+}
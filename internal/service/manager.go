@@ -1,25 +1,51 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"go-crawler-client/config"
 	"go-crawler-client/internal/model"
+	"go-crawler-client/internal/pkg/fsutil"
 	"go-crawler-client/internal/pkg/logger"
 )
 
 type Task struct {
 	ID       string
-	Status   string // running, completed, failed
-	Mode     string // image or data
-	UserInfo model.UserInfo
+	Status   string         // running, paused, interrupted, completed, cancelled, failed
+	Mode     string         // image, data, novel, bookmarks, search or ranking
+	Scope    string         // user, search or ranking: what the task is keyed by
+	Target   string         // user ID, search word or ranking the task was started for
+	UserInfo model.UserInfo // empty for tasks that are not scoped to a user
+	Options  model.TaskOptions
+	Guest    bool               // started without cookie or account, restarts as a guest too
+	Dir      string             // root directory of the task's files, e.g. crawl-datas/<uid>
 	Logger   *logger.TaskLogger // every task has its own logger
 	Results  []model.TaskResult // crawled data results
 	Images   []model.ImageInfo  // downloaded image information
-	mu       sync.RWMutex       // task-level lock to protect concurrent read/write of Results and Images
+	Stats    model.TaskStats    // new / updated / skipped works
+	ErrCode  string             // why a failed task failed, e.g. auth_expired
+	Err      string
+	mu       sync.RWMutex // task-level lock to protect concurrent read/write of Results and Images
+
+	ctx     context.Context    // cancelled by Cancel, every request of the crawler uses it
+	cancel  context.CancelFunc // cancels ctx
+	resumed chan struct{}      // non-nil while paused, closed by Resume
+
+	// Crawl frontier, checkpointed so the task can continue after a restart
+	pending        map[string]model.WorkRef // discovered works not finished yet, by WorkRef.Key
+	done           map[string]struct{}      // finished works, by WorkRef.Key
+	listingDone    bool                     // every work of the task has been discovered
+	checkpointedAt time.Time
+	checkpointMu   sync.Mutex // one checkpoint written at a time, in the order they were taken
+
+	// Matches of a task capped by max_works, held back until every match is known
+	held     []HeldWork
+	selected map[string]bool // held matches picked to be downloaded, by WorkRef.Key
 }
 
 // TaskManager manages all tasks
@@ -41,9 +67,8 @@ func InitTaskManager() {
 	}
 }
 
-func (tm *TaskManager) AddTask(taskID string, mode string, userInfo model.UserInfo) (*Task, error) {
-	baseDir := config.GetBaseDir()
-	userDir := filepath.Join(baseDir, "crawl-datas", userInfo.UserID)
+func (tm *TaskManager) AddTask(taskID string, mode string, userInfo model.UserInfo, options model.TaskOptions) (*Task, error) {
+	scope, target, userDir := taskScope(mode, userInfo, options)
 
 	// Initialize user directories
 	dirs := []string{
@@ -51,7 +76,9 @@ func (tm *TaskManager) AddTask(taskID string, mode string, userInfo model.UserIn
 		".task_data",
 		".task_logs",
 		".download_imgs",
+		".download_novels",
 		".task_results",
+		".index",
 	}
 	for _, d := range dirs {
 		path := filepath.Join(userDir, d)
@@ -68,14 +95,23 @@ func (tm *TaskManager) AddTask(taskID string, mode string, userInfo model.UserIn
 		return nil, err
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	task := &Task{
+		ctx:      ctx,
+		cancel:   cancel,
 		ID:       taskID,
 		Status:   "running",
 		Mode:     mode,
+		Scope:    scope,
+		Target:   target,
 		UserInfo: userInfo,
+		Options:  options,
+		Dir:      userDir,
 		Logger:   l,
 		Results:  make([]model.TaskResult, 0),
 		Images:   make([]model.ImageInfo, 0),
+		pending:  make(map[string]model.WorkRef),
+		done:     make(map[string]struct{}),
 	}
 
 	// Store in sync.Map
@@ -83,6 +119,26 @@ func (tm *TaskManager) AddTask(taskID string, mode string, userInfo model.UserIn
 	return task, nil
 }
 
+// taskScope returns what a task is keyed by and the directory it stores its files in.
+// Most tasks belong to a Pixiv user, searches and rankings get a directory of their own instead
+func taskScope(mode string, userInfo model.UserInfo, options model.TaskOptions) (scope string, target string, dir string) {
+	root := filepath.Join(config.GetBaseDir(), "crawl-datas")
+
+	switch {
+	case mode == "search" && options.Search != nil:
+		word := options.Search.Word
+		return "search", word, filepath.Join(root, "search", fsutil.SanitizeName(word))
+	case mode == "ranking" && options.Ranking != nil:
+		ranking := options.Ranking.Mode + "_" + options.Ranking.Content
+		target = ranking
+		if options.Ranking.Date != "" {
+			target += "_" + options.Ranking.Date
+		}
+		return "ranking", target, filepath.Join(root, "ranking", fsutil.SanitizeName(ranking))
+	}
+	return "user", userInfo.UserID, filepath.Join(root, userInfo.UserID)
+}
+
 func (tm *TaskManager) GetTask(taskID string) (*Task, bool) {
 	val, ok := tm.tasks.Load(taskID)
 	if !ok {
@@ -106,6 +162,86 @@ func (t *Task) UpdateStatus(status string) {
 	t.Status = status
 }
 
+// Context returns the context of the task, it is done once the task is cancelled
+func (t *Task) Context() context.Context {
+	return t.ctx
+}
+
+// Cancel stops a running or paused task, the crawler keeps what it has finished so far.
+// A resumable task is dropped for good
+func (t *Task) Cancel() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.Status == "running", t.Status == "paused":
+	case t.resumable():
+		t.RemoveCheckpoint()
+	default:
+		return fmt.Errorf("task is %s", t.Status)
+	}
+	t.Status = "cancelled"
+	t.cancel()
+	t.Logger.Info("Task cancelled")
+	return nil
+}
+
+// Fail stops a running or paused task, recording why. The crawler keeps what it has finished so far,
+// and the checkpoint: the task resumes once its session is fixed
+func (t *Task) Fail(code string, message string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Status != "running" && t.Status != "paused" {
+		return
+	}
+	t.Status = "failed"
+	t.ErrCode = code
+	t.Err = message
+	t.cancel()
+	t.Logger.Error("Task failed (%s): %s", code, message)
+}
+
+// Pause holds back every request of a running task until Resume or Cancel
+func (t *Task) Pause() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Status != "running" {
+		return fmt.Errorf("task is %s", t.Status)
+	}
+	t.Status = "paused"
+	t.resumed = make(chan struct{})
+	t.Logger.Info("Task paused")
+	return nil
+}
+
+// Resume lets a paused task continue
+func (t *Task) Resume() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Status != "paused" {
+		return fmt.Errorf("task is %s", t.Status)
+	}
+	t.Status = "running"
+	close(t.resumed)
+	t.resumed = nil
+	t.Logger.Info("Task resumed")
+	return nil
+}
+
+// WaitIfPaused blocks while the task is paused. It returns an error once the task is cancelled
+func (t *Task) WaitIfPaused() error {
+	t.mu.RLock()
+	resumed := t.resumed
+	t.mu.RUnlock()
+
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-t.ctx.Done():
+		}
+	}
+	return t.ctx.Err()
+}
+
 func (t *Task) AddResult(result model.TaskResult) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -118,6 +254,41 @@ func (t *Task) AddImage(image model.ImageInfo) {
 	t.Images = append(t.Images, image)
 }
 
+// CountWork counts a work as "new", "updated" or "skipped"
+func (t *Task) CountWork(outcome string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch outcome {
+	case "new":
+		t.Stats.New++
+	case "updated":
+		t.Stats.Updated++
+	case "skipped":
+		t.Stats.Skipped++
+	}
+}
+
+// CountFiltered counts a work left out by the task filter
+func (t *Task) CountFiltered(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Stats.Filtered == nil {
+		t.Stats.Filtered = make(map[string]int)
+	}
+	t.Stats.Filtered[reason]++
+}
+
+// AcceptWork counts a work that passed the task filter, unless max works (0: no limit) were already accepted
+func (t *Task) AcceptWork(max int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if max > 0 && t.Stats.Matched >= max {
+		return false
+	}
+	t.Stats.Matched++
+	return true
+}
+
 func (t *Task) GetSnapshot() model.TaskStatusResponse {
 	// Acquire read lock: prevent conflicts when reading data while the crawler is writing new data
 	t.mu.RLock()
@@ -128,15 +299,26 @@ func (t *Task) GetSnapshot() model.TaskStatusResponse {
 	logs, _ := t.Logger.GetLogs(50)
 
 	resp := model.TaskStatusResponse{
-		Status:   t.Status,
-		Mode:     t.Mode,
-		UserInfo: t.UserInfo,
-		Logs:     logs,
+		Status:    t.Status,
+		Mode:      t.Mode,
+		Scope:     t.Scope,
+		Target:    t.Target,
+		UserInfo:  t.UserInfo,
+		Logs:      logs,
+		Stats:     t.Stats,
+		ErrorCode: t.ErrCode,
+		Error:     t.Err,
+	}
+	if t.Stats.Filtered != nil {
+		resp.Stats.Filtered = make(map[string]int, len(t.Stats.Filtered))
+		for reason, n := range t.Stats.Filtered {
+			resp.Stats.Filtered[reason] = n
+		}
 	}
 
-	// Only return full results when the task is completed
+	// Only return full results when the task is completed (or cancelled / failed, with what it finished)
 	// This helps reduce the amount of data transferred and avoids returning huge JSON while running
-	if t.Status == "completed" {
+	if t.Status == "completed" || t.Status == "cancelled" || t.Status == "failed" {
 		resp.Results = t.Results
 		resp.Images = t.Images
 	} else {
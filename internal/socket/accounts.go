@@ -0,0 +1,239 @@
+package socket
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"go-crawler-client/internal/account"
+	"go-crawler-client/internal/crawler"
+	"go-crawler-client/internal/model"
+)
+
+// Account vault commands
+//
+// Pixiv accounts are registered once with "add_account" and kept encrypted on the client, tasks then name
+// them with "account" instead of sending a cookie. "import_cookies" registers one from the cookie file of a
+// browser instead of a hand copied Cookie header. Cookies never go back to the backend: "list_accounts"
+// and every other answer only carry the cookie names
+
+type accountPayload struct {
+	Alias  string `json:"alias"`
+	Cookie string `json:"cookie"`
+}
+
+// accountCheck is the answer of add_account and test_account
+type accountCheck struct {
+	Success bool              `json:"success"`
+	Code    string            `json:"code,omitempty"` // auth_expired, r18_disabled
+	Message string            `json:"message,omitempty"`
+	Account model.AccountInfo `json:"account"`
+	Auth    *model.AuthStatus `json:"auth,omitempty"`
+}
+
+// handleAddAccount tests a cookie and stores it in the vault, replacing the account of the same alias
+func (c *Client) handleAddAccount(reqID string, payload json.RawMessage) {
+	var req accountPayload
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return
+	}
+	if account.GlobalVault == nil {
+		c.sendResponse(reqID, map[string]string{"error": "Account vault is not available"})
+		return
+	}
+	if err := account.ValidateAlias(req.Alias); err != nil {
+		c.sendResponse(reqID, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.Cookie == "" {
+		c.sendResponse(reqID, map[string]string{"error": "missing required field: cookie"})
+		return
+	}
+
+	// Only logged in cookies are worth keeping
+	auth, err := crawler.CheckCookie(req.Cookie)
+	if err != nil {
+		c.sendAuthFailed(reqID, err)
+		return
+	}
+
+	c.saveAccount(reqID, req.Alias, req.Cookie, auth)
+}
+
+// saveAccount stores a tested cookie under an alias and answers with the account.
+// Replacing an account keeps the date it was first added
+func (c *Client) saveAccount(reqID string, alias string, cookie string, auth model.AuthStatus) {
+	acc := account.Account{
+		Alias:    alias,
+		Cookie:   cookie,
+		UserID:   auth.UserID,
+		UserName: auth.UserName,
+		Premium:  auth.Premium,
+	}
+	if old, ok := account.GlobalVault.Get(alias); ok {
+		acc.AddedAt = old.AddedAt
+	}
+	if err := account.GlobalVault.Put(acc); err != nil {
+		c.sendResponse(reqID, map[string]string{"error": "Failed to save account: " + err.Error()})
+		return
+	}
+	account.GlobalVault.RecordCheck(alias, "ok", auth)
+
+	acc, _ = account.GlobalVault.Get(alias)
+	c.sendResponse(reqID, accountCheck{Success: true, Account: acc.Info(), Auth: &auth})
+}
+
+// importPayload names a cookie file on the client machine, or carries its content in base64
+type importPayload struct {
+	Alias  string `json:"alias"`
+	Format string `json:"format"` // netscape or firefox, guessed from the content when empty
+	Path   string `json:"path"`
+	Data   string `json:"data"`
+}
+
+// maxCookieFileSize bounds the files read by import_cookies, a cookies.sqlite is a few MB at most
+const maxCookieFileSize = 64 << 20
+
+// handleImportCookies imports the pixiv.net cookies of a browser export (cookies.txt) or of a Firefox
+// profile (cookies.sqlite), checks the session they carry and stores it like add_account
+func (c *Client) handleImportCookies(reqID string, payload json.RawMessage) {
+	var req importPayload
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return
+	}
+	if account.GlobalVault == nil {
+		c.sendResponse(reqID, map[string]string{"error": "Account vault is not available"})
+		return
+	}
+	if err := account.ValidateAlias(req.Alias); err != nil {
+		c.sendResponse(reqID, map[string]string{"error": err.Error()})
+		return
+	}
+
+	data, wal, err := readCookieFile(req)
+	if err != nil {
+		c.sendResponse(reqID, map[string]string{"error": "Failed to read cookie file: " + err.Error()})
+		return
+	}
+	cookie, err := account.ImportCookies(data, wal, req.Format)
+	if err != nil {
+		c.sendResponse(reqID, map[string]string{"error": "Failed to import cookies: " + err.Error()})
+		return
+	}
+
+	auth, err := crawler.CheckImportedCookie(cookie)
+	if err != nil {
+		c.sendAuthFailed(reqID, err)
+		return
+	}
+	c.saveAccount(reqID, req.Alias, cookie, auth)
+}
+
+// readCookieFile returns the content of the cookie file of an import. A cookies.sqlite read from disk comes
+// with its -wal file when Firefox left one, the latest logins are often only there
+func readCookieFile(req importPayload) ([]byte, []byte, error) {
+	switch {
+	case req.Data != "":
+		data, err := base64.StdEncoding.DecodeString(req.Data)
+		return data, nil, err
+	case req.Path == "":
+		return nil, nil, errors.New("missing required field: path or data")
+	}
+
+	data, err := readLimited(req.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.Format == account.FormatNetscape || account.DetectFormat(data) != account.FormatFirefox {
+		return data, nil, nil
+	}
+	wal, err := readLimited(req.Path + "-wal")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	return data, wal, nil
+}
+
+func readLimited(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxCookieFileSize {
+		return nil, fmt.Errorf("%s is larger than %d MB", path, maxCookieFileSize>>20)
+	}
+	return os.ReadFile(path)
+}
+
+// handleListAccounts lists the accounts of the vault, cookies redacted
+func (c *Client) handleListAccounts(reqID string) {
+	if account.GlobalVault == nil {
+		c.sendResponse(reqID, map[string]string{"error": "Account vault is not available"})
+		return
+	}
+	accounts := account.GlobalVault.List()
+	infos := make([]model.AccountInfo, 0, len(accounts))
+	for _, acc := range accounts {
+		infos = append(infos, acc.Info())
+	}
+	c.sendResponse(reqID, infos)
+}
+
+// handleTestAccount checks that the cookie of an account is still logged in and records the outcome
+func (c *Client) handleTestAccount(reqID string, payload json.RawMessage) {
+	var req accountPayload
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return
+	}
+	if account.GlobalVault == nil {
+		c.sendResponse(reqID, map[string]string{"error": "Account vault is not available"})
+		return
+	}
+	acc, ok := account.GlobalVault.Get(req.Alias)
+	if !ok {
+		c.sendResponse(reqID, map[string]string{"error": "Account not found"})
+		return
+	}
+
+	auth, err := crawler.CheckCookie(acc.Cookie)
+	result := accountCheck{Success: err == nil}
+	status := "ok"
+	if err != nil {
+		status = "error"
+		result.Message = err.Error()
+		var authErr *crawler.AuthError
+		if errors.As(err, &authErr) {
+			status = authErr.Code
+			result.Code = authErr.Code
+		}
+	} else {
+		result.Auth = &auth
+	}
+	account.GlobalVault.RecordCheck(req.Alias, status, auth)
+
+	acc, _ = account.GlobalVault.Get(req.Alias)
+	result.Account = acc.Info()
+	c.sendResponse(reqID, result)
+}
+
+// handleRemoveAccount deletes an account from the vault
+func (c *Client) handleRemoveAccount(reqID string, payload json.RawMessage) {
+	var req accountPayload
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return
+	}
+	if account.GlobalVault == nil {
+		c.sendResponse(reqID, map[string]string{"error": "Account vault is not available"})
+		return
+	}
+	if err := account.GlobalVault.Remove(req.Alias); err != nil {
+		c.sendResponse(reqID, map[string]string{"error": err.Error()})
+		return
+	}
+	c.sendResponse(reqID, map[string]interface{}{
+		"success": true,
+		"alias":   req.Alias,
+	})
+}
@@ -3,15 +3,18 @@ package socket
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"go-crawler-client/config"
+	"go-crawler-client/internal/account"
 	"go-crawler-client/internal/crawler"
 	"go-crawler-client/internal/model"
 	"go-crawler-client/internal/service"
@@ -23,7 +26,11 @@ import (
 type Client struct {
 	BackendURL string
 	Token      string // This is the User's Auth Token (JWT) to identify the WS connection
-	Conn       *websocket.Conn
+
+	writeMu   sync.Mutex      // the connection allows a single writer, streams write from their own goroutines
+	conn      *websocket.Conn // current connection, nil while disconnected, guarded by writeMu
+	streamsMu sync.Mutex
+	streams   map[string]*fileStream // file transfers in progress, by request ID
 }
 
 func NewClient(backendURL, token string) *Client {
@@ -66,20 +73,28 @@ func (c *Client) Connect() {
 	headers := http.Header{}
 	headers.Add("Authorization", "Bearer "+c.Token)
 
+	// Same proxy as the Pixiv requests
+	dialer := websocket.Dialer{
+		NetDialContext:   crawler.DialContext,
+		HandshakeTimeout: 45 * time.Second,
+	}
+
 	for {
 		log.Printf("Connecting to %s...", u.String())
-		conn, _, err := websocket.DefaultDialer.Dial(u.String(), headers)
+		conn, _, err := dialer.Dial(u.String(), headers)
 		if err != nil {
 			log.Printf("Connection failed: %v. Retrying in 5s...", err)
 			time.Sleep(5 * time.Second)
 			continue
 		}
 
-		c.Conn = conn
+		c.writeMu.Lock()
+		c.conn = conn
+		c.writeMu.Unlock()
 		log.Println("\033[32mConnected to Backend via WebSocket!\033[0m")
 
 		// Listen loop
-		c.listen()
+		c.listen(conn)
 
 		// If listen returns, it means disconnected
 		log.Println("Disconnected. Reconnecting...")
@@ -87,10 +102,16 @@ func (c *Client) Connect() {
 	}
 }
 
-func (c *Client) listen() {
-	defer c.Conn.Close()
+func (c *Client) listen(conn *websocket.Conn) {
+	defer func() {
+		c.writeMu.Lock()
+		c.conn = nil
+		c.writeMu.Unlock()
+		conn.Close()
+	}()
+	defer c.cancelStreams()
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
 			log.Println("Read error:", err)
 			return
@@ -110,6 +131,7 @@ type StartTaskPayload struct {
 	Cookie      string `json:"cookie"`
 	Token       string `json:"token"` // Task Token
 	Mode        string `json:"mode"`
+	model.TaskOptions
 }
 
 func (c *Client) handleMessage(data []byte) {
@@ -129,14 +151,48 @@ func (c *Client) handleMessage(data []byte) {
 		c.handleStartTask(msg.ID, payload)
 	case "get_status":
 		c.handleGetStatus(msg.ID, msg.Payload)
+	case "cancel_task":
+		c.handleControlTask(msg.ID, msg.Payload, (*service.Task).Cancel)
+	case "pause_task":
+		c.handleControlTask(msg.ID, msg.Payload, (*service.Task).Pause)
+	case "resume_task":
+		c.handleResumeTask(msg.ID, msg.Payload)
 	case "get_logs":
 		c.handleGetLogs(msg.ID, msg.Payload)
 	case "get_config":
 		c.handleGetConfig(msg.ID)
+	case "get_health":
+		c.sendResponse(msg.ID, model.HealthResponse{
+			Status:     "ok",
+			BaseDir:    config.GetBaseDir(),
+			TasksCount: service.GlobalTaskManager.Count(),
+			Accounts:   account.Usage(),
+			Proxies:    crawler.GetProxyStatus(),
+		})
 	case "get_avatar":
 		c.handleGetAvatar(msg.ID, msg.Payload)
 	case "get_image":
 		c.handleGetImage(msg.ID, msg.Payload)
+	case "stream_ack":
+		c.handleStreamAck(msg.ID, msg.Payload)
+	case "stream_cancel":
+		c.handleStreamCancel(msg.ID)
+	case "verify":
+		c.handleVerify(msg.ID, msg.Payload)
+	case "get_limits":
+		c.sendResponse(msg.ID, crawler.GetRateLimits())
+	case "set_limits":
+		c.handleSetLimits(msg.ID, msg.Payload)
+	case "add_account":
+		c.handleAddAccount(msg.ID, msg.Payload)
+	case "import_cookies":
+		c.handleImportCookies(msg.ID, msg.Payload)
+	case "list_accounts":
+		c.handleListAccounts(msg.ID)
+	case "test_account":
+		c.handleTestAccount(msg.ID, msg.Payload)
+	case "remove_account":
+		c.handleRemoveAccount(msg.ID, msg.Payload)
 	default:
 		log.Println("Unknown message type:", msg.Type)
 	}
@@ -145,22 +201,48 @@ func (c *Client) handleMessage(data []byte) {
 func (c *Client) handleStartTask(reqID string, req StartTaskPayload) {
 	log.Printf("Received Start Task: Mode=%s, User=%s", req.Mode, req.PixivUserID)
 
-	// 1. Get User Info
-	userInfo, err := crawler.GetUserInfo(req.PixivUserID, req.Cookie)
+	if err := crawler.ValidateTask(req.Mode, req.PixivUserID, req.TaskOptions); err != nil {
+		c.sendResponse(reqID, map[string]interface{}{
+			"success": false,
+			"message": "Invalid task: " + err.Error(),
+		})
+		return
+	}
+
+	// 1. Validate the cookies, an expired session would "complete" the task with works missing.
+	// The cookies of registered accounts never leave the client, tasks without any cookie crawl as a guest
+	pool, err := account.NewPool(req.AccountAliases(), req.Cookie)
 	if err != nil {
-		log.Println("Failed to get user info:", err)
 		c.sendResponse(reqID, map[string]interface{}{
 			"success": false,
-			"message": "Failed to get user info: " + err.Error(),
+			"message": "Invalid task: " + err.Error(),
 		})
 		return
 	}
+	if err := crawler.CheckPool(req.Mode, pool, req.TaskOptions); err != nil {
+		c.sendAuthFailed(reqID, err)
+		return
+	}
 
-	// 2. Generate Task ID
+	// 2. Get User Info (search tasks are not tied to a user)
+	var userInfo model.UserInfo
+	if crawler.RequiresUser(req.Mode) {
+		userInfo, err = crawler.ResolveUser(req.Mode, req.PixivUserID, pool)
+		if err != nil {
+			log.Println("Failed to get user info:", err)
+			c.sendResponse(reqID, map[string]interface{}{
+				"success": false,
+				"message": "Failed to get user info: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	// 3. Generate Task ID
 	taskID := uuid.New().String()
 
-	// 3. Create Task
-	task, err := service.GlobalTaskManager.AddTask(taskID, req.Mode, userInfo)
+	// 4. Create Task
+	task, err := service.GlobalTaskManager.AddTask(taskID, req.Mode, userInfo, req.TaskOptions)
 	if err != nil {
 		log.Println("Failed to create task:", err)
 		c.sendResponse(reqID, map[string]interface{}{
@@ -169,11 +251,12 @@ func (c *Client) handleStartTask(reqID string, req StartTaskPayload) {
 		})
 		return
 	}
+	task.Guest = pool.Guest()
 
-	// 4. Start Crawler
-	go crawler.StartCrawler(task, req.Cookie)
+	// 5. Start Crawler
+	go c.runTask(c.currentConn(), reqID, task, pool)
 
-	// 5. Send Response
+	// 6. Send Response
 	c.sendResponse(reqID, model.StartTaskResponse{
 		Status:   "running",
 		TaskID:   taskID,
@@ -181,6 +264,40 @@ func (c *Client) handleStartTask(reqID string, req StartTaskPayload) {
 	})
 }
 
+// runTask runs the crawler of a task, then reports a task that failed with an error code,
+// such as auth_expired, to the request that started it on conn
+func (c *Client) runTask(conn *websocket.Conn, reqID string, task *service.Task, pool *account.Pool) {
+	crawler.StartCrawler(task, pool)
+
+	snapshot := task.GetSnapshot()
+	if snapshot.ErrorCode == "" {
+		return
+	}
+	c.sendMessage(conn, reqID, "task_error", map[string]string{
+		"task_id":    task.ID,
+		"status":     snapshot.Status,
+		"error_code": snapshot.ErrorCode,
+		"error":      snapshot.Error,
+	})
+}
+
+// sendAuthFailed answers a failed cookie check, sessions that cannot run the task come with their error code
+func (c *Client) sendAuthFailed(reqID string, err error) {
+	var authErr *crawler.AuthError
+	if errors.As(err, &authErr) {
+		c.sendResponse(reqID, map[string]interface{}{
+			"success": false,
+			"code":    authErr.Code,
+			"message": authErr.Message,
+		})
+		return
+	}
+	c.sendResponse(reqID, map[string]interface{}{
+		"success": false,
+		"message": "Failed to check cookie: " + err.Error(),
+	})
+}
+
 func (c *Client) sendResponse(reqID string, payload interface{}) {
 	msg := Message{
 		ID:      reqID,
@@ -192,7 +309,41 @@ func (c *Client) sendResponse(reqID string, payload interface{}) {
 	msg.Payload = data
 
 	respData, _ := json.Marshal(msg)
-	c.Conn.WriteMessage(websocket.TextMessage, respData)
+	c.writeMessage(c.currentConn(), websocket.TextMessage, respData)
+}
+
+// sendMessage sends a message of the given type about a request received on conn
+func (c *Client) sendMessage(conn *websocket.Conn, reqID string, msgType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msgData, err := json.Marshal(Message{ID: reqID, Type: msgType, Payload: data})
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(conn, websocket.TextMessage, msgData)
+}
+
+// errConnClosed drops the writes about a request whose connection closed, its request ID means nothing
+// to the connection that replaced it
+var errConnClosed = errors.New("connection closed")
+
+// currentConn returns the connection the requests are read from
+func (c *Client) currentConn() *websocket.Conn {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn
+}
+
+// writeMessage writes a frame to conn, one writer at a time, unless conn is no longer the current connection
+func (c *Client) writeMessage(conn *websocket.Conn, messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if conn == nil || conn != c.conn {
+		return errConnClosed
+	}
+	return conn.WriteMessage(messageType, data)
 }
 
 func (c *Client) handleGetStatus(reqID string, payload json.RawMessage) {
@@ -211,6 +362,88 @@ func (c *Client) handleGetStatus(reqID string, payload json.RawMessage) {
 	c.sendResponse(reqID, task.GetSnapshot())
 }
 
+// handleControlTask applies cancel / pause / resume to a task
+func (c *Client) handleControlTask(reqID string, payload json.RawMessage, action func(*service.Task) error) {
+	var req struct {
+		TaskID string `json:"task_id"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return
+	}
+
+	task, ok := service.GlobalTaskManager.GetTask(req.TaskID)
+	if !ok {
+		c.sendResponse(reqID, map[string]string{"error": "Task not found"})
+		return
+	}
+
+	if err := action(task); err != nil {
+		c.sendResponse(reqID, map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.sendResponse(reqID, map[string]interface{}{
+		"success": true,
+		"task_id": req.TaskID,
+		"status":  task.GetSnapshot().Status,
+	})
+}
+
+// handleResumeTask resumes a paused task, or restarts a task interrupted by a client restart or failed by its session
+// (which needs the cookie again unless the task uses registered accounts or was started as a guest, cookies are never written to checkpoints)
+func (c *Client) handleResumeTask(reqID string, payload json.RawMessage) {
+	var req struct {
+		TaskID string `json:"task_id"`
+		Cookie string `json:"cookie"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return
+	}
+
+	task, ok := service.GlobalTaskManager.GetTask(req.TaskID)
+	if !ok || !task.Resumable() {
+		c.handleControlTask(reqID, payload, (*service.Task).Resume)
+		return
+	}
+
+	pool, err := account.NewPool(task.Options.AccountAliases(), req.Cookie)
+	if err != nil {
+		c.sendResponse(reqID, map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// Only tasks started as a guest restart without a session
+	if pool.Guest() && !task.Guest {
+		c.sendResponse(reqID, map[string]interface{}{
+			"success": false,
+			"message": "Missing cookie, required to restart the task",
+		})
+		return
+	}
+	if err := crawler.CheckPool(task.Mode, pool, task.Options); err != nil {
+		c.sendAuthFailed(reqID, err)
+		return
+	}
+	if err := task.Reopen(); err != nil {
+		c.sendResponse(reqID, map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	go c.runTask(c.currentConn(), reqID, task, pool)
+
+	c.sendResponse(reqID, map[string]interface{}{
+		"success": true,
+		"task_id": req.TaskID,
+		"status":  "running",
+	})
+}
+
 func (c *Client) handleGetLogs(reqID string, payload json.RawMessage) {
 	var req struct {
 		TaskID string `json:"task_id"`
@@ -244,9 +477,11 @@ func (c *Client) handleGetLogs(reqID string, payload json.RawMessage) {
 func (c *Client) handleGetConfig(reqID string) {
 	c.sendResponse(reqID, model.ConfigResponse{
 		User: model.UserConfig{
-			ServerURL: config.GlobalConfig.ServerURL,
-			ProxyHost: config.GlobalConfig.ProxyHost,
-			ProxyPort: config.GlobalConfig.ProxyPort,
+			ServerURL:   config.GlobalConfig.ServerURL,
+			ProxyMode:   config.GlobalConfig.ProxyMode,
+			ProxyHost:   config.GlobalConfig.ProxyHost,
+			ProxyPort:   config.GlobalConfig.ProxyPort,
+			ProxyBypass: config.GlobalConfig.ProxyBypass,
 		},
 		Client: model.ClientConfig{
 			BaseDir:   config.GetBaseDir(),
@@ -255,9 +490,24 @@ func (c *Client) handleGetConfig(reqID string) {
 	})
 }
 
+// handleSetLimits changes the process-wide request limits, fields left out keep their current value
+func (c *Client) handleSetLimits(reqID string, payload json.RawMessage) {
+	limits := crawler.GetRateLimits()
+	if err := json.Unmarshal(payload, &limits); err != nil {
+		c.sendResponse(reqID, map[string]string{"error": "Invalid limits: " + err.Error()})
+		return
+	}
+	if err := crawler.SetRateLimits(limits); err != nil {
+		c.sendResponse(reqID, map[string]string{"error": err.Error()})
+		return
+	}
+	c.sendResponse(reqID, crawler.GetRateLimits())
+}
+
 func (c *Client) handleGetAvatar(reqID string, payload json.RawMessage) {
 	var req struct {
 		PixivUserID string `json:"pixiv_user_id"`
+		StreamRequest
 	}
 	if err := json.Unmarshal(payload, &req); err != nil {
 		return
@@ -273,6 +523,11 @@ func (c *Client) handleGetAvatar(reqID string, payload json.RawMessage) {
 		return
 	}
 
+	if req.Stream {
+		c.startStream(reqID, avatarPath, req.StreamRequest)
+		return
+	}
+
 	// Read file
 	data, err := os.ReadFile(avatarPath)
 	if err != nil {
@@ -286,37 +541,71 @@ func (c *Client) handleGetAvatar(reqID string, payload json.RawMessage) {
 	c.sendResponse(reqID, map[string]string{"data": encoded})
 }
 
+// handleVerify re-hashes a user's downloaded files against the archive index
+func (c *Client) handleVerify(reqID string, payload json.RawMessage) {
+	var req struct {
+		PixivUserID string `json:"pixiv_user_id"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return
+	}
+
+	dir, err := crawler.UserArchiveDir(req.PixivUserID)
+	if err != nil {
+		c.sendResponse(reqID, map[string]string{"error": err.Error()})
+		return
+	}
+
+	report, err := crawler.VerifyArchive(dir)
+	if err != nil {
+		c.sendResponse(reqID, map[string]string{"error": err.Error()})
+		return
+	}
+	c.sendResponse(reqID, report)
+}
+
 func (c *Client) handleGetImage(reqID string, payload json.RawMessage) {
 	var req struct {
 		PixivUserID string `json:"pixiv_user_id"`
 		Filename    string `json:"filename"`
+		Size        int    `json:"size"` // longest side of a JPEG preview (256 or 1024), 0 for the original
+		StreamRequest
 	}
 	if err := json.Unmarshal(payload, &req); err != nil {
 		return
 	}
 
-	baseDir := config.GetBaseDir()
-	// Path: crawl-datas/<uid>/.download_imgs/<filename>
-	// Note: The user mentioned "crawl-datas/crawl-datas/..." in their example.
-	// But based on handleGetAvatar which uses "crawl-datas/<uid>/.avatars",
-	// I assume the structure is consistent: "crawl-datas/<uid>/.download_imgs".
-	// If the user has a nested "crawl-datas" folder, it might be because GetBaseDir() returns a path ending in crawl-datas?
-	// Or maybe they just created a folder named crawl-datas inside crawl-datas.
-	// I will stick to the pattern used in handleGetAvatar.
-	imagePath := filepath.Join(baseDir, "crawl-datas", req.PixivUserID, ".download_imgs", req.Filename)
-
-	// Check if file exists
-	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+	// Path: crawl-datas/<uid>/.download_imgs/<filename>, where filename may be a path under .download_imgs
+	// (filename templates can nest directories) or the legacy {illust_id}_p{page}.{ext} name of the file
+	userDir, err := crawler.UserArchiveDir(req.PixivUserID)
+	if err != nil {
+		c.sendResponse(reqID, map[string]string{"error": err.Error()})
+		return
+	}
+	imagePath, err := crawler.FindImageFile(userDir, req.Filename)
+	if err != nil {
 		// Try checking if there is a double crawl-datas folder as per user example, just in case
-		imagePath2 := filepath.Join(baseDir, "crawl-datas", "crawl-datas", req.PixivUserID, ".download_imgs", req.Filename)
-		if _, err2 := os.Stat(imagePath2); os.IsNotExist(err2) {
+		userDir = filepath.Join(config.GetBaseDir(), "crawl-datas", "crawl-datas", req.PixivUserID)
+		imagePath, err = crawler.FindImageFile(userDir, req.Filename)
+		if err != nil {
 			c.sendResponse(reqID, map[string]string{"error": "Image not found"})
 			return
-		} else {
-			imagePath = imagePath2
 		}
 	}
 
+	if req.Size > 0 {
+		imagePath, err = crawler.PreviewFile(userDir, imagePath, req.Size)
+		if err != nil {
+			c.sendResponse(reqID, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	if req.Stream {
+		c.startStream(reqID, imagePath, req.StreamRequest)
+		return
+	}
+
 	// Read file
 	data, err := os.ReadFile(imagePath)
 	if err != nil {
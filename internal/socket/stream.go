@@ -0,0 +1,264 @@
+package socket
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Streamed file transfer
+//
+// A get_image or get_avatar request with "stream": true is answered with:
+//   - a "stream_start" text message: size, mime, sha256 of the whole file, the offset the transfer starts at,
+//     the chunk size and the window
+//   - binary frames of at most streamChunkSize bytes of data, each laid out as
+//     [1 byte: length n of the request ID][n bytes: request ID][8 bytes: big-endian file offset][data],
+//     so request IDs of more than streamMaxIDLen bytes are refused
+//   - a "stream_end" text message once every byte was sent, or "stream_error" when the transfer failed
+//
+// The backend acknowledges with {"id": <request ID>, "type": "stream_ack", "payload": {"offset": <bytes received>}}.
+// No more than window chunks are sent ahead of the last acknowledged offset. A transfer that dropped can be
+// resumed with "offset" set to the bytes already received, and stopped early with "stream_cancel".
+const (
+	streamChunkSize     = 64 * 1024
+	streamDefaultWindow = 16
+	streamMaxWindow     = 256
+	streamAckTimeout    = 60 * time.Second
+	streamMaxIDLen      = 255 // the length prefix of binary frames is a single byte
+)
+
+// StreamRequest holds the streaming options of a file request
+type StreamRequest struct {
+	Stream bool  `json:"stream"`
+	Offset int64 `json:"offset"` // resume at this byte
+	Window int   `json:"window"` // chunks sent ahead of the last ack, 0 for the default
+}
+
+type StreamStart struct {
+	Size      int64  `json:"size"`
+	Mime      string `json:"mime"`
+	SHA256    string `json:"sha256"`
+	Offset    int64  `json:"offset"`
+	ChunkSize int    `json:"chunk_size"`
+	Window    int    `json:"window"`
+}
+
+type StreamEnd struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Sent   int64  `json:"sent"` // bytes sent by this transfer, from offset to the end
+}
+
+// fileStream is a transfer in progress, acks from the read loop reach it through acked
+type fileStream struct {
+	mu       sync.Mutex
+	acked    int64
+	ackCh    chan struct{}
+	cancelCh chan struct{}
+	once     sync.Once
+}
+
+func (s *fileStream) ack(offset int64) {
+	s.mu.Lock()
+	if offset > s.acked {
+		s.acked = offset
+	}
+	s.mu.Unlock()
+	select {
+	case s.ackCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *fileStream) cancel() {
+	s.once.Do(func() { close(s.cancelCh) })
+}
+
+// waitWindow blocks until fewer than window bytes are unacknowledged at pos
+func (s *fileStream) waitWindow(pos int64, window int64) error {
+	for {
+		s.mu.Lock()
+		acked := s.acked
+		s.mu.Unlock()
+		if pos-acked < window {
+			return nil
+		}
+
+		timer := time.NewTimer(streamAckTimeout)
+		select {
+		case <-s.ackCh:
+			timer.Stop()
+		case <-s.cancelCh:
+			timer.Stop()
+			return errors.New("cancelled")
+		case <-timer.C:
+			return fmt.Errorf("no ack for %s", streamAckTimeout)
+		}
+	}
+}
+
+// startStream checks the file and sends it in the background, so acks keep being read meanwhile
+func (c *Client) startStream(reqID string, path string, opts StreamRequest) {
+	if len(reqID) > streamMaxIDLen {
+		c.sendResponse(reqID, map[string]string{"error": fmt.Sprintf("Request ID too long to stream, at most %d bytes", streamMaxIDLen)})
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		c.sendResponse(reqID, map[string]string{"error": "File not found"})
+		return
+	}
+	if opts.Offset < 0 || opts.Offset > info.Size() {
+		c.sendResponse(reqID, map[string]string{"error": fmt.Sprintf("Invalid offset %d, file size is %d", opts.Offset, info.Size())})
+		return
+	}
+	window := opts.Window
+	if window <= 0 {
+		window = streamDefaultWindow
+	}
+	window = min(window, streamMaxWindow)
+
+	stream := &fileStream{
+		acked:    opts.Offset,
+		ackCh:    make(chan struct{}, 1),
+		cancelCh: make(chan struct{}),
+	}
+	c.streamsMu.Lock()
+	if c.streams == nil {
+		c.streams = make(map[string]*fileStream)
+	}
+	if old, ok := c.streams[reqID]; ok {
+		old.cancel()
+	}
+	c.streams[reqID] = stream
+	c.streamsMu.Unlock()
+
+	conn := c.currentConn()
+	go func() {
+		defer func() {
+			c.streamsMu.Lock()
+			if c.streams[reqID] == stream {
+				delete(c.streams, reqID)
+			}
+			c.streamsMu.Unlock()
+		}()
+
+		if err := c.runStream(conn, reqID, path, opts.Offset, window, stream); err != nil {
+			log.Printf("Stream %s of %s failed: %v", reqID, path, err)
+			c.sendMessage(conn, reqID, "stream_error", map[string]string{"error": err.Error()})
+		}
+	}()
+}
+
+func (c *Client) runStream(conn *websocket.Conn, reqID string, path string, offset int64, window int, stream *fileStream) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The checksum covers the whole file, so a resumed transfer can be verified once reassembled
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	head := make([]byte, 512)
+	n, _ := f.ReadAt(head, 0)
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(head[:n])
+	}
+
+	if err := c.sendMessage(conn, reqID, "stream_start", StreamStart{
+		Size:      size,
+		Mime:      mimeType,
+		SHA256:    sum,
+		Offset:    offset,
+		ChunkSize: streamChunkSize,
+		Window:    window,
+	}); err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	prefix := make([]byte, 0, 1+len(reqID)+8)
+	prefix = append(prefix, byte(len(reqID)))
+	prefix = append(prefix, reqID...)
+	frame := make([]byte, cap(prefix)+streamChunkSize)
+	copy(frame, prefix)
+
+	pos := offset
+	for pos < size {
+		if err := stream.waitWindow(pos, int64(window)*streamChunkSize); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(f, frame[len(prefix)+8:])
+		if n == 0 {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		binary.BigEndian.PutUint64(frame[len(prefix):], uint64(pos))
+		if err := c.writeMessage(conn, websocket.BinaryMessage, frame[:len(prefix)+8+n]); err != nil {
+			return err
+		}
+		pos += int64(n)
+	}
+
+	return c.sendMessage(conn, reqID, "stream_end", StreamEnd{Size: size, SHA256: sum, Sent: pos - offset})
+}
+
+// handleStreamAck routes an ack of the backend to its transfer
+func (c *Client) handleStreamAck(reqID string, payload json.RawMessage) {
+	var req struct {
+		Offset int64 `json:"offset"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return
+	}
+	c.streamsMu.Lock()
+	stream, ok := c.streams[reqID]
+	c.streamsMu.Unlock()
+	if ok {
+		stream.ack(req.Offset)
+	}
+}
+
+// handleStreamCancel stops a transfer the backend no longer wants
+func (c *Client) handleStreamCancel(reqID string) {
+	c.streamsMu.Lock()
+	stream, ok := c.streams[reqID]
+	c.streamsMu.Unlock()
+	if ok {
+		stream.cancel()
+	}
+}
+
+// cancelStreams stops every transfer, their connection is gone
+func (c *Client) cancelStreams() {
+	c.streamsMu.Lock()
+	defer c.streamsMu.Unlock()
+	for id, stream := range c.streams {
+		stream.cancel()
+		delete(c.streams, id)
+	}
+}